@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,17 +9,14 @@ import (
 	"github.com/urfave/negroni"
 
 	"github.com/wrapp/gokit/kit"
+	kitlog "github.com/wrapp/gokit/log"
 	"github.com/wrapp/gokit/middleware/errormw"
 	"github.com/wrapp/gokit/middleware/jsonrqmw"
 	"github.com/wrapp/gokit/middleware/requestidmw"
 	"github.com/wrapp/gokit/wrpctx"
 )
 
-func requestIDGetter(ctx context.Context) func() string {
-	return func() string {
-		return requestidmw.IDFromCtx(ctx)
-	}
-}
+//var client = trace.New(nil)
 
 type App struct {
 	controller Controller
@@ -40,8 +36,7 @@ func (a *App) indexHandler(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, "(%s) %s", requestidmw.IDFromCtx(ctx), "Welcome to the home page!")
 	log.WithFields(log.Fields(wrpctx.GetMap(ctx))).Info("Log context...")
 
-	//c := trace.New(requestIDGetter(ctx))
-	//c.Get("http://localhost:8080/err")
+	//resp, err := client.GetContext(ctx, "http://localhost:8080/err")
 }
 
 func (a *App) errHandler(w http.ResponseWriter, req *http.Request) error {
@@ -79,6 +74,9 @@ func (a *App) init() {
 	a.controller = Controller{}
 }
 func main() {
+	// print the logs of the global logrus logger through the gokit logger
+	kitlog.SetGlobal(kitlog.Default())
+
 	app := &App{}
 	app.init()
 
@@ -0,0 +1,32 @@
+// testutil contains helpers which are shared by the tests of the gokit packages.
+package testutil
+
+import (
+	"sync"
+	"testing"
+)
+
+// FakeT is a testing.TB which records the failures reported through Errorf instead of failing
+// the test, e.g to check that an assertion helper fails. It is safe for concurrent use.
+type FakeT struct {
+	testing.TB
+	mu     sync.Mutex
+	failed bool
+}
+
+// Helper does nothing, the failures are not reported.
+func (f *FakeT) Helper() {}
+
+// Errorf records the failure.
+func (f *FakeT) Errorf(format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed = true
+}
+
+// Failed reports whether Errorf was called.
+func (f *FakeT) Failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed
+}
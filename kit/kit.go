@@ -12,13 +12,19 @@ import (
 
 	"github.com/wrapp/gokit/env"
 	kitlog "github.com/wrapp/gokit/log"
+	"github.com/wrapp/gokit/middleware/deadlinemw"
 	"github.com/wrapp/gokit/middleware/recoverymw"
 	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/middleware/spanmw"
 	"github.com/wrapp/gokit/middleware/wrpctxmw"
+	"github.com/wrapp/gokit/trace"
 )
 
 type ShutdownHandlerFunc func()
 
+// flushTimeout is the maximum time to wait for the spans to be exported on shutdown.
+const flushTimeout = 10 * time.Second
+
 // Service interface provides the functionality of any service. It allows you to
 // define your implementation of a service if you need to.
 type Service interface {
@@ -30,12 +36,21 @@ type Service interface {
 	ListenAndServe(string) error
 }
 
+// LoggerService is implemented by the services of this package, which log with their own
+// logger instead of the default one. A Service can be asserted to it e.g to read its logger.
+type LoggerService interface {
+	Service
+	SetLogger(*kitlog.Logger)
+	Logger() *kitlog.Logger
+}
+
 type service struct {
 	drainConn    bool
 	timeout      time.Duration
 	preShutdown  ShutdownHandlerFunc
 	postShutdown ShutdownHandlerFunc
 	handler      *negroni.Negroni
+	logger       *kitlog.Logger
 }
 
 // Handler returns the http.Handler of the service. When a service is started this handler is
@@ -60,7 +75,19 @@ func (s *service) DrainConnections(drain bool, timeout time.Duration) {
 // SetServiceName sets the name of the service for all default components. If there are
 // custom components then programmer has the responsibility to set those properly.
 func (s *service) SetServiceName(name string) {
-	kitlog.SetServiceName(name)
+	s.logger.SetServiceName(name)
+}
+
+// SetLogger sets the logger of the service. The default logger is used if no logger is set.
+// Middlewares are not affected by this, they should be created with the same logger e.g
+// through SimpleServiceWithLogger.
+func (s *service) SetLogger(logger *kitlog.Logger) {
+	s.logger = logger
+}
+
+// Logger returns the logger of the service.
+func (s *service) Logger() *kitlog.Logger {
+	return s.logger
 }
 
 // SetPreShutdownHandler sets a custom `handler` function which is called just before service
@@ -87,7 +114,17 @@ func (s *service) SetPostShutdownHandler(handler ShutdownHandlerFunc) {
 
 // By default all the timeouts (ReadTimeout, WriteTimeout, IdleTimeout, ReadHeaderTimeout)
 // are set to 60s. These timeouts are set to avoid memory leaks.
+
+// The service logger is closed, which writes its buffered entries, and the default tracer is
+// shut down, which exports its spans, before the function returns.
 func (s *service) ListenAndServe(addr string) error {
+	defer s.logger.Close()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		trace.DefaultTracer().Shutdown(ctx)
+	}()
+
 	srv := http.Server{
 		Addr:              addr,
 		Handler:           s.handler,
@@ -116,8 +153,9 @@ func (s *service) ListenAndServe(addr string) error {
 				s.preShutdown()
 			}
 
-			ctx, _ := context.WithTimeout(context.Background(), s.timeout)
+			ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
 			err = srv.Shutdown(ctx)
+			cancel()
 
 			if s.postShutdown != nil {
 				s.postShutdown()
@@ -134,10 +172,15 @@ func (s *service) ListenAndServe(addr string) error {
 // NewService creates a new service with all the custom handlers provided in the arguments.
 // This will not add any default handlers in the service.
 func NewService(handlers ...negroni.Handler) Service {
+	return newService(handlers...)
+}
+
+func newService(handlers ...negroni.Handler) *service {
 	return &service{
 		drainConn: true,
 		timeout:   25 * time.Second,
 		handler:   negroni.New(handlers...),
+		logger:    kitlog.Default(),
 	}
 }
 
@@ -148,15 +191,28 @@ func NewService(handlers ...negroni.Handler) Service {
 /*
 	- Wrapp Context (wrpctxmw) is a wrapper around `context.Context`.
 	- Request ID (requestidmw) adds a unique id for each incoming request.
+	- Span (spanmw) records a span for each incoming request.
+	- Deadline (deadlinemw) applies the deadline of the caller to the request context.
 	- Recovery (recoverymw) provides functionality to recover from panics in the http.Handler.
 */
 func SimpleService(handler http.Handler) Service {
-	s := NewService(
+	return SimpleServiceWithLogger(handler, kitlog.Default())
+}
+
+// SimpleServiceWithLogger initializes the service with the same middlewares as SimpleService.
+// The passed logger is used by the service and the middlewares instead of the default logger.
+func SimpleServiceWithLogger(handler http.Handler, logger *kitlog.Logger) Service {
+	s := newService(
 		wrpctxmw.New(),
 		requestidmw.New(),
-		recoverymw.New(),
+		spanmw.New(),
+		deadlinemw.NewWithLogger(logger),
+		recoverymw.NewWithLogger(logger),
 		negroni.Wrap(handler),
 	)
-	s.SetServiceName(env.ServiceName())
+	s.SetLogger(logger)
+	if logger.ServiceName() == "" {
+		s.SetServiceName(env.ServiceName())
+	}
 	return s
 }
@@ -1,21 +1,34 @@
 package kit
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 
+	"github.com/wrapp/gokit/log/logtest"
+	"github.com/wrapp/gokit/middleware/baggagemw"
+	"github.com/wrapp/gokit/middleware/compressmw"
+	"github.com/wrapp/gokit/middleware/deadlinemw"
 	"github.com/wrapp/gokit/middleware/errormw"
 	"github.com/wrapp/gokit/middleware/jsonrqmw"
 	"github.com/wrapp/gokit/middleware/recoverymw"
 	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/middleware/spanmw"
 	"github.com/wrapp/gokit/middleware/wrpctxmw"
+	"github.com/wrapp/gokit/trace"
 	"github.com/wrapp/gokit/wrpctx"
 )
 
@@ -58,7 +71,7 @@ func testGenerateRequestID(t *testing.T) {
 	service.Handler().ServeHTTP(w, r)
 
 	if id := w.Header().Get("X-Request-Id"); id == "" {
-		t.Errorf("X-Request-Id was not set in header", id)
+		t.Errorf("X-Request-Id was not set in header")
 	}
 
 	if b := w.Body.String(); b == "" {
@@ -266,3 +279,370 @@ func TestRecoveryMW(t *testing.T) {
 		t.Errorf("Expected 'PANIC!: do panic' in body")
 	}
 }
+
+func TestServiceLogger(t *testing.T) {
+	t.Parallel()
+
+	rec := logtest.New(t)
+	service := SimpleServiceWithLogger(panicHandler{}, rec.Logger)
+
+	if ls, ok := service.(LoggerService); !ok || ls.Logger() != rec.Logger {
+		t.Errorf("Expected service to use the passed logger")
+	}
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	service.Handler().ServeHTTP(w, r)
+
+	rec.AssertLogged(log.ErrorLevel, "PANIC! in http handler", map[string]interface{}{
+		"service": "test",
+		"panic":   "do panic",
+	})
+}
+
+type spanTestHandler struct{}
+
+func (h spanTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	span := requestidmw.SpanFromCtx(r.Context())
+	fmt.Fprintf(w, "%s|%s|%s|%s", span.TraceID, span.SpanID, span.ParentID, wrpctx.Get(r.Context(), "trace_id"))
+}
+
+func TestTraceContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ContinueTrace", func(t *testing.T) {
+		t.Parallel()
+		service := NewService(wrpctxmw.New(), requestidmw.New(), negroni.Wrap(spanTestHandler{}))
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		parts := strings.Split(w.Body.String(), "|")
+		if parts[0] != "4bf92f3577b34da6a3ce929d0e0e4736" || parts[2] != "00f067aa0ba902b7" || parts[3] != parts[0] {
+			t.Errorf("Unexpected span %q", w.Body.String())
+		}
+		if parts[1] == "00f067aa0ba902b7" || len(parts[1]) != 16 {
+			t.Errorf("Expected a new child span-id got %q", parts[1])
+		}
+		if tp := w.Header().Get("traceresponse"); tp != "00-"+parts[0]+"-"+parts[1]+"-01" {
+			t.Errorf("traceresponse = %q does not match the span", tp)
+		}
+	})
+
+	t.Run("B3", func(t *testing.T) {
+		t.Parallel()
+		service := NewService(wrpctxmw.New(), requestidmw.XRequestIDHandler{
+			GenerateFunc: requestidmw.DefaultGenFunc(),
+			B3:           true,
+		}, negroni.Wrap(spanTestHandler{}))
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-B3-TraceId", "a3ce929d0e0e4736")
+		r.Header.Set("X-B3-SpanId", "00f067aa0ba902b7")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		if !strings.HasPrefix(w.Body.String(), "0000000000000000a3ce929d0e0e4736|") {
+			t.Errorf("Unexpected span %q", w.Body.String())
+		}
+		if w.Header().Get("X-B3-ParentSpanId") != "00f067aa0ba902b7" {
+			t.Errorf("Expected B3 headers in response got %v", w.Header())
+		}
+	})
+
+	t.Run("NewTrace", func(t *testing.T) {
+		t.Parallel()
+		service := NewService(wrpctxmw.New(), requestidmw.New(), negroni.Wrap(spanTestHandler{}))
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		parts := strings.Split(w.Body.String(), "|")
+		if len(parts[0]) != 32 || parts[0] == "00000000000000000000000000000000" || parts[2] != "" {
+			t.Errorf("Expected a new trace got %q", w.Body.String())
+		}
+	})
+}
+
+func TestSpanMW(t *testing.T) {
+	t.Parallel()
+
+	var got *trace.Span
+	handler := negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanFromContext(r.Context())
+		got.SetAttribute("order", 42)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	tracer := trace.NewTracer("test", nil, trace.NeverSample())
+	service := NewService(wrpctxmw.New(), requestidmw.New(), spanmw.SpanHandler{Tracer: tracer}, handler)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	service.Handler().ServeHTTP(w, r)
+
+	if got == nil || got.SpanContext().Sampled {
+		t.Fatalf("Expected an unsampled span in the context got %+v", got)
+	}
+	if tp := w.Header().Get("traceresponse"); !strings.HasSuffix(tp, "-00") {
+		t.Errorf("Expected unsampled traceresponse got %q", tp)
+	}
+}
+
+func TestBaggageMW(t *testing.T) {
+	t.Parallel()
+	keys := []string{"tenant_id", "user_id", "cohort"}
+
+	downstream := httptest.NewServer(NewService(wrpctxmw.New(), baggagemw.BaggageHandler{Keys: keys, Prefix: "X-Baggage-"},
+		negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m := wrpctx.GetMap(r.Context())
+			fmt.Fprintf(w, "%v|%v|%v|%v|%s", m["tenant_id"], m["user_id"], m["cohort"], m["internal"], r.Header.Get("baggage"))
+		}))).Handler())
+	defer downstream.Close()
+
+	newClient := func(hosts ...string) *trace.TraceClient {
+		client := trace.New(nil)
+		client.BaggageKeys = keys
+		client.BaggageHosts = hosts
+		client.BaggagePrefix = "X-Baggage-"
+		client.Tracer = trace.NewTracer("test", nil, nil)
+		return client
+	}
+	clients := map[string]*trace.TraceClient{"/": newClient("127.0.0.1"), "/external": newClient(".internal")}
+	service := NewService(wrpctxmw.New(), baggagemw.New(keys...),
+		negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrpctx.Set(r.Context(), "internal", "not forwarded")
+			wrpctx.Set(r.Context(), "cohort", 7)
+			resp, err := clients[r.URL.Path].GetContext(r.Context(), downstream.URL)
+			if err != nil {
+				t.Errorf("Request failed with %q", err)
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(w, resp.Body)
+		})))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("baggage", "tenant_id=acme,secret=x,user_id=jane%20doe;prop=1")
+	w := httptest.NewRecorder()
+	service.Handler().ServeHTTP(w, r)
+
+	expected := "acme|jane doe|7|<nil>|cohort=7,tenant_id=acme,user_id=jane%20doe"
+	if w.Body.String() != expected {
+		t.Errorf("Expected %q got %q", expected, w.Body.String())
+	}
+
+	t.Run("ExternalHost", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/external", nil)
+		r.Header.Set("baggage", "tenant_id=acme")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+		if expected := "<nil>|<nil>|<nil>|<nil>|"; w.Body.String() != expected {
+			t.Errorf("Expected no baggage sent to an external host got %q", w.Body.String())
+		}
+	})
+
+	t.Run("SizeLimit", func(t *testing.T) {
+		h := http.Header{}
+		baggagemw.SetInHeader(&h, map[string]string{"tenant_id": strings.Repeat("a", baggagemw.MaxSize)}, "")
+		if h.Get("baggage") != "" {
+			t.Errorf("Expected baggage larger than MaxSize to be dropped")
+		}
+		h.Set("baggage", "tenant_id="+strings.Repeat("a", baggagemw.MaxSize)+",user_id=jane")
+		if b := baggagemw.FromHeader(h, keys, ""); len(b) != 1 || b["user_id"] != "jane" {
+			t.Errorf("Expected only the entries within MaxSize got %v", b)
+		}
+	})
+}
+
+type deadlineTestHandler struct{}
+
+func (h deadlineTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deadline, ok := r.Context().Deadline()
+	if !ok {
+		fmt.Fprint(w, "none")
+		return
+	}
+	fmt.Fprint(w, time.Until(deadline).Milliseconds())
+}
+
+func TestDeadlineMW(t *testing.T) {
+	t.Parallel()
+
+	remaining := func(t *testing.T, body string) int {
+		ms, err := strconv.Atoi(body)
+		if err != nil {
+			t.Fatalf("Expected a deadline got %q", body)
+		}
+		return ms
+	}
+
+	t.Run("Propagated", func(t *testing.T) {
+		t.Parallel()
+		downstream := httptest.NewServer(NewService(deadlinemw.New(), negroni.Wrap(deadlineTestHandler{})).Handler())
+		defer downstream.Close()
+
+		client := trace.New(nil)
+		client.Tracer = trace.NewTracer("test", nil, nil)
+		service := NewService(deadlinemw.New(), negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp, err := client.GetContext(r.Context(), downstream.URL)
+			if err != nil {
+				t.Errorf("Request failed with %q", err)
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(w, resp.Body)
+		})))
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-Timeout-Ms", "300")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		if ms := remaining(t, w.Body.String()); ms <= 0 || ms > 300 {
+			t.Errorf("Expected the downstream deadline to be within 300ms got %dms", ms)
+		}
+	})
+
+	t.Run("Capped", func(t *testing.T) {
+		t.Parallel()
+		service := NewService(deadlinemw.DeadlineHandler{MaxTimeout: 100 * time.Millisecond}, negroni.Wrap(deadlineTestHandler{}))
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-Timeout-Ms", "60000")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		if ms := remaining(t, w.Body.String()); ms > 100 {
+			t.Errorf("Expected the deadline to be capped at 100ms got %dms", ms)
+		}
+
+		for _, timeout := range []string{"9300000000000", "10000000000000000"} {
+			r, _ = http.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Request-Timeout-Ms", timeout)
+			w = httptest.NewRecorder()
+			service.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusOK || remaining(t, w.Body.String()) > 100 {
+				t.Errorf("Expected the timeout %sms beyond time.Duration to be capped at 100ms got %d %q", timeout, w.Code, w.Body.String())
+			}
+		}
+
+		r, _ = http.NewRequest("GET", "/", nil)
+		w = httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+		if w.Body.String() != "none" {
+			t.Errorf("Expected no deadline without the header got %q", w.Body.String())
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+		rec := logtest.New(t)
+		called := false
+		service := NewService(deadlinemw.NewWithLogger(rec.Logger), negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})))
+
+		r, _ := http.NewRequest("GET", "/orders", nil)
+		r.Header.Set("X-Request-Timeout-Ms", "0")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		if called || w.Code != deadlinemw.StatusDeadlineExceeded {
+			t.Errorf("Expected the request to be rejected with %d got %d", deadlinemw.StatusDeadlineExceeded, w.Code)
+		}
+		rec.AssertLogged(log.WarnLevel, "request deadline exceeded", map[string]interface{}{"path": "/orders", "timeout_ms": 0})
+	})
+
+	t.Run("ExpiresInHandler", func(t *testing.T) {
+		t.Parallel()
+		rec := logtest.New(t)
+		service := NewService(deadlinemw.NewWithLogger(rec.Logger), negroni.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})))
+
+		r, _ := http.NewRequest("GET", "/orders", nil)
+		r.Header.Set("X-Request-Timeout-Ms", "20")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		if w.Code != deadlinemw.StatusDeadlineExceeded {
+			t.Errorf("Expected status %d got %d", deadlinemw.StatusDeadlineExceeded, w.Code)
+		}
+		rec.AssertLogged(log.WarnLevel, "request deadline exceeded", map[string]interface{}{"timeout_ms": 20})
+	})
+}
+
+type compressTestHandler struct{}
+
+func (h compressTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	fmt.Fprintf(w, "%q %s", r.Header.Get("Content-Encoding"), body)
+}
+
+func TestCompressMW(t *testing.T) {
+	t.Parallel()
+	payload := strings.Repeat(`{"name":"order"}`, 100)
+
+	t.Run("EndToEnd", func(t *testing.T) {
+		t.Parallel()
+		var encoding string
+		downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding = r.Header.Get("Content-Encoding")
+			NewService(compressmw.New(), negroni.Wrap(compressTestHandler{})).Handler().ServeHTTP(w, r)
+		}))
+		defer downstream.Close()
+
+		client := trace.New(nil)
+		client.Tracer = trace.NewTracer("test", nil, nil)
+		client.Compression = trace.NewCompression()
+		client.Compression.MinSize = 1024
+		resp, err := client.Post(downstream.URL, "application/json", strings.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if encoding != "gzip" || string(body) != `"" `+payload {
+			t.Errorf("Expected a gzip body to reach the handler decompressed got %q %q", encoding, body)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		t.Parallel()
+		service := NewService(compressmw.New(), negroni.Wrap(compressTestHandler{}))
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(payload))
+		r.Header.Set("Content-Encoding", "br")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Accept-Encoding") != compressmw.AcceptEncoding {
+			t.Errorf("Expected status %d got %d", http.StatusUnsupportedMediaType, w.Code)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(payload))
+		zw.Close()
+
+		service := NewService(compressmw.DecompressHandler{MaxSize: 100}, negroni.Wrap(compressTestHandler{}))
+		r, _ := http.NewRequest("POST", "/", &buf)
+		r.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		service.Handler().ServeHTTP(w, r)
+
+		if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), compressmw.ErrTooLarge.Error()) {
+			t.Errorf("Expected the body to be limited got %d %q", w.Code, w.Body.String())
+		}
+	})
+}
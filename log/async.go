@@ -0,0 +1,180 @@
+package log
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OverflowPolicy decides what an AsyncWriter does with a new entry when its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until there is space in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued entry to make space for the new entry.
+	OverflowDropOldest
+	// OverflowDropNewest drops the new entry.
+	OverflowDropNewest
+)
+
+// AsyncWriter is an io.Writer which queues the written entries and writes them to the
+// underlying writer in a separate goroutine. The queue is bounded and the OverflowPolicy
+// decides what happens when it is full. Dropped entries are counted and can be read with
+// Dropped.
+// AsyncWriter is also a logrus hook for fatal and panic entries. When such an entry is logged
+// the queue is flushed and the writer switches to synchronous writes so that the entry is
+// written before the program exits. The writer stays synchronous afterwards. The writer is only
+// stopped by Close.
+type AsyncWriter struct {
+	out     io.Writer
+	policy  OverflowPolicy
+	queue   chan []byte
+	flushes chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	dropped uint64
+	// sync is set to 1 once the writer writes synchronously.
+	sync int32
+
+	// state guards closed. Writers hold the read lock while they enqueue so that the queue is
+	// not abandoned while an entry is being added.
+	state  sync.RWMutex
+	closed bool
+	// mu serialises the writes to out.
+	mu sync.Mutex
+}
+
+// NewAsyncWriter creates an AsyncWriter which writes to `out`. The queue holds at most `size`
+// entries.
+func NewAsyncWriter(out io.Writer, size int, policy OverflowPolicy) *AsyncWriter {
+	w := &AsyncWriter{
+		out:     out,
+		policy:  policy,
+		queue:   make(chan []byte, size),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a copy of `p` to be written to the underlying writer. It always reports that
+// all of `p` was written, even if the entry was dropped.
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	w.state.RLock()
+	defer w.state.RUnlock()
+
+	if w.closed || atomic.LoadInt32(&w.sync) == 1 {
+		return w.write(p)
+	}
+
+	b := make([]byte, len(p))
+	copy(b, p)
+
+	switch w.policy {
+	case OverflowDropNewest:
+		select {
+		case w.queue <- b:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case w.queue <- b:
+				return len(p), nil
+			default:
+			}
+			select {
+			case <-w.queue:
+				atomic.AddUint64(&w.dropped, 1)
+			default:
+			}
+		}
+	default:
+		w.queue <- b
+	}
+	return len(p), nil
+}
+
+// Flush blocks until all the queued entries are written to the underlying writer.
+func (w *AsyncWriter) Flush() error {
+	w.state.RLock()
+	defer w.state.RUnlock()
+
+	if w.closed {
+		return nil
+	}
+	ack := make(chan struct{})
+	w.flushes <- ack
+	<-ack
+	return nil
+}
+
+// Close flushes the queue and stops the writer goroutine. Entries written after Close are
+// written synchronously. Close does not close the underlying writer.
+func (w *AsyncWriter) Close() error {
+	w.state.Lock()
+	defer w.state.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	<-w.stopped
+	return nil
+}
+
+// Dropped returns the number of entries which were dropped because the queue was full.
+func (w *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Levels returns the levels of the entries which are written synchronously.
+func (w *AsyncWriter) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel}
+}
+
+// Fire switches the writer to synchronous writes and flushes the queue.
+func (w *AsyncWriter) Fire(*log.Entry) error {
+	atomic.StoreInt32(&w.sync, 1)
+	return w.Flush()
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.stopped)
+	for {
+		select {
+		case p := <-w.queue:
+			w.write(p)
+		case ack := <-w.flushes:
+			w.drain()
+			close(ack)
+		case <-w.done:
+			w.drain()
+			return
+		}
+	}
+}
+
+func (w *AsyncWriter) drain() {
+	for {
+		select {
+		case p := <-w.queue:
+			w.write(p)
+		default:
+			return
+		}
+	}
+}
+
+func (w *AsyncWriter) write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
@@ -0,0 +1,133 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// blockingWriter blocks every write until release is closed.
+type blockingWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncWriterFlush(t *testing.T) {
+	t.Parallel()
+
+	out := &blockingWriter{release: make(chan struct{})}
+	close(out.release)
+	w := NewAsyncWriter(out, 10, OverflowBlock)
+	for i := 0; i < 100; i++ {
+		w.Write([]byte("a"))
+	}
+	w.Flush()
+
+	if got := out.String(); got != strings.Repeat("a", 100) {
+		t.Errorf("Expected 100 entries got %d", len(got))
+	}
+	if w.Dropped() != 0 {
+		t.Errorf("Expected no dropped entries got %d", w.Dropped())
+	}
+}
+
+func TestAsyncWriterDropNewest(t *testing.T) {
+	t.Parallel()
+
+	out := &blockingWriter{release: make(chan struct{})}
+	w := NewAsyncWriter(out, 2, OverflowDropNewest)
+	w.Write([]byte("1"))
+	for i := 0; i < 10; i++ {
+		w.Write([]byte("x"))
+	}
+	close(out.release)
+	w.Close()
+
+	if w.Dropped() == 0 {
+		t.Errorf("Expected dropped entries")
+	}
+	if got := out.String(); !strings.HasPrefix(got, "1") {
+		t.Errorf("Expected first entry to be written got %q", got)
+	}
+}
+
+func TestAsyncWriterDropOldest(t *testing.T) {
+	t.Parallel()
+
+	out := &blockingWriter{release: make(chan struct{})}
+	w := NewAsyncWriter(out, 2, OverflowDropOldest)
+	for i := 0; i < 10; i++ {
+		w.Write([]byte{byte('0' + i)})
+	}
+	close(out.release)
+	w.Close()
+
+	if w.Dropped() == 0 {
+		t.Errorf("Expected dropped entries")
+	}
+	if got := out.String(); !strings.HasSuffix(got, "89") {
+		t.Errorf("Expected newest entries to be written got %q", got)
+	}
+}
+
+func TestLoggerAsyncSyncOnPanic(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+	w := l.SetAsync(10, OverflowBlock)
+
+	l.Info("queued")
+	func() {
+		defer func() { recover() }()
+		l.Panic("boom")
+	}()
+
+	if out := buf.String(); !strings.Contains(out, "queued") || !strings.Contains(out, "boom") {
+		t.Errorf("Expected entries to be written synchronously got %q", out)
+	}
+	l.Info("after")
+	if !strings.Contains(buf.String(), "after") {
+		t.Errorf("Expected writer to stay synchronous")
+	}
+	if w.Dropped() != 0 {
+		t.Errorf("Expected no dropped entries got %d", w.Dropped())
+	}
+}
+
+func TestLoggerSetAsyncTwice(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+	first := l.SetAsync(10, OverflowBlock)
+	l.SetAsync(10, OverflowBlock)
+	l.Info("once")
+	l.Close()
+
+	if n := strings.Count(buf.String(), "once"); n != 1 {
+		t.Errorf("Expected the entry to be written once got %d", n)
+	}
+	if n := len(l.Hooks[log.PanicLevel]); n != 1 || !first.closed {
+		t.Errorf("Expected the previous writer to be closed and replaced got %d hooks", n)
+	}
+}
@@ -1,3 +1,8 @@
 // `log` package provides a default log formatter which prints the log in JSON format on
 // stdout. This also adds some extra fields which are added in the log entry before printing.
+// The output format can be changed to colourised text for local development or logfmt
+// through `LOG_FORMAT` environment variable.
+// The formatter is used through Logger which is a logrus logger with its own service name,
+// output, level and static fields. Importing this package does not change the global logrus
+// logger, SetGlobal can be used to opt in.
 package log
@@ -0,0 +1,155 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxErrorChain is the maximum number of errors which are walked in an Unwrap chain.
+const maxErrorChain = 32
+
+// statusError matches errors which carry an http status code e.g errormw.StatusError.
+type statusError interface {
+	error
+	Status() int
+}
+
+// stackError is an error which carries the stacktrace of where it was created. See WithStack.
+type stackError struct {
+	err   error
+	stack []string
+}
+
+func (e *stackError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stackError) Unwrap() error {
+	return e.err
+}
+
+// StackTrace returns the stacktrace of where the error was created. Each frame is formatted
+// as `function file:line`.
+func (e *stackError) StackTrace() []string {
+	return e.stack
+}
+
+// WithStack returns an error which wraps the passed error and records the stacktrace of the
+// caller. The stacktrace is printed in the `stack` key when the error is logged. WithStack
+// returns nil if the passed error is nil.
+func WithStack(err error) error {
+	if err == nil {
+		return nil
+	}
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	var stack []string
+	for {
+		frame, more := frames.Next()
+		stack = append(stack, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+		if !more {
+			break
+		}
+	}
+	return &stackError{err: err, stack: stack}
+}
+
+// errorFields returns a copy of the fields where every error value is replaced by its
+// structured form. See structuredError.
+func errorFields(data log.Fields) log.Fields {
+	fields := make(log.Fields, len(data))
+	for k, v := range data {
+		if err, ok := v.(error); ok && err != nil {
+			fields[k] = structuredError(err)
+			continue
+		}
+		fields[k] = v
+	}
+	return fields
+}
+
+// structuredError converts an error into a map which contains its `message`, its `type` and
+// the `chain` of wrapped errors. The `stack` of the innermost error which carries a stacktrace
+// and the http `status` of the first error which has one are added if they are present.
+func structuredError(err error) map[string]interface{} {
+	m := map[string]interface{}{
+		"message": err.Error(),
+		"type":    fmt.Sprintf("%T", err),
+	}
+
+	var chain []map[string]interface{}
+	var stack []string
+	walkErrors(err, func(e error) {
+		chain = append(chain, map[string]interface{}{
+			"message": e.Error(),
+			"type":    fmt.Sprintf("%T", e),
+		})
+		if s := stackTrace(e); s != nil {
+			stack = s
+		}
+	})
+	m["chain"] = chain
+	if stack != nil {
+		m["stack"] = stack
+	}
+
+	var serr statusError
+	if errors.As(err, &serr) {
+		m["status"] = serr.Status()
+	}
+	return m
+}
+
+// walkErrors calls `fn` for the error and all the errors in its Unwrap chain in depth-first
+// order. Both `Unwrap() error` and `Unwrap() []error` are followed.
+func walkErrors(err error, fn func(error)) {
+	queue := []error{err}
+	for n := 0; len(queue) > 0 && n < maxErrorChain; n++ {
+		e := queue[0]
+		queue = queue[1:]
+		fn(e)
+
+		switch u := e.(type) {
+		case interface{ Unwrap() error }:
+			if next := u.Unwrap(); next != nil {
+				queue = append([]error{next}, queue...)
+			}
+		case interface{ Unwrap() []error }:
+			var next []error
+			for _, c := range u.Unwrap() {
+				if c != nil {
+					next = append(next, c)
+				}
+			}
+			queue = append(next, queue...)
+		}
+	}
+}
+
+// stackTrace returns the stacktrace of the error if it has a `StackTrace` method which returns
+// a slice, e.g errors created by WithStack or by github.com/pkg/errors. Otherwise it returns nil.
+func stackTrace(err error) []string {
+	if s, ok := err.(*stackError); ok {
+		return s.stack
+	}
+
+	m := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+		return nil
+	}
+	frames := m.Call(nil)[0]
+	if frames.Kind() != reflect.Slice {
+		return nil
+	}
+
+	stack := make([]string, frames.Len())
+	for i := range stack {
+		stack[i] = fmt.Sprintf("%+v", frames.Index(i).Interface())
+	}
+	return stack
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/wrapp/gokit/middleware/errormw"
+)
+
+func TestStructuredError(t *testing.T) {
+	t.Parallel()
+
+	base := WithStack(errormw.NewError(404, "order not found"))
+	err := fmt.Errorf("loading order: %w", base)
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+	l.WithError(err).Error("request failed")
+
+	var m struct {
+		Error struct {
+			Message string                   `json:"message"`
+			Type    string                   `json:"type"`
+			Chain   []map[string]interface{} `json:"chain"`
+			Stack   []string                 `json:"stack"`
+			Status  int                      `json:"status"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", buf.String())
+	}
+
+	if m.Error.Message != "loading order: order not found" || m.Error.Type != "*fmt.wrapError" {
+		t.Errorf("Unexpected error %+v", m.Error)
+	}
+	if len(m.Error.Chain) != 3 || m.Error.Chain[2]["type"] != "errormw.statusError" {
+		t.Errorf("Unexpected chain %v", m.Error.Chain)
+	}
+	if m.Error.Status != 404 {
+		t.Errorf("status = %d wanted 404", m.Error.Status)
+	}
+	if len(m.Error.Stack) == 0 || !strings.Contains(m.Error.Stack[0], "TestStructuredError") {
+		t.Errorf("Unexpected stack %v", m.Error.Stack)
+	}
+}
+
+func TestStructuredErrorJoined(t *testing.T) {
+	t.Parallel()
+
+	err := errors.Join(errors.New("first"), errors.New("second"))
+	m := structuredError(err)
+	if chain := m["chain"].([]map[string]interface{}); len(chain) != 3 || chain[2]["message"] != "second" {
+		t.Errorf("Unexpected chain %v", chain)
+	}
+	if _, ok := m["status"]; ok {
+		t.Errorf("Expected no status in %v", m)
+	}
+}
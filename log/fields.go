@@ -0,0 +1,91 @@
+package log
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/env"
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+// clashPrefix is prepended to the key of an entry field which has the same key as a static
+// field. Static fields are never overwritten by entry fields.
+const clashPrefix = "fields."
+
+// staticFieldEnvs maps the static fields which are discovered automatically to the environment
+// variables they are read from. The first variable which is set is used.
+var staticFieldEnvs = map[string][]string{
+	"hostname":    {"HOSTNAME"},
+	"pod":         {"POD_NAME"},
+	"region":      {"REGION", "AWS_REGION"},
+	"environment": {"ENVIRONMENT", "ENV"},
+	"version":     {"VERSION", "SERVICE_VERSION"},
+}
+
+// defaultStaticFields returns the static fields which are discovered from the environment
+// variables. The hostname falls back to the hostname reported by the OS. Fields which cannot
+// be discovered are left out.
+func defaultStaticFields() log.Fields {
+	fields := log.Fields{}
+	for key, names := range staticFieldEnvs {
+		for _, name := range names {
+			if v := env.Get(name); v != "" {
+				fields[key] = v
+				break
+			}
+		}
+	}
+	if _, ok := fields["hostname"]; !ok {
+		if hostname, err := os.Hostname(); err == nil && hostname != "" {
+			fields["hostname"] = hostname
+		}
+	}
+	return fields
+}
+
+// contextKeys are the wrpctx fields which are added to an entry which has a context e.g
+// through WithContext. They are set by requestidmw.
+var contextKeys = []string{"request_id", "trace_id", "span_id"}
+
+// contextFields returns a copy of the entry fields with the request-id, trace-id and span-id
+// from the wrpctx of the entry context added. Fields of the entry are not overwritten.
+func contextFields(entry *log.Entry) log.Fields {
+	fields := make(log.Fields, len(entry.Data)+len(contextKeys))
+	for k, v := range entry.Data {
+		fields[k] = v
+	}
+	if entry.Context == nil {
+		return fields
+	}
+	for _, k := range contextKeys {
+		if _, ok := fields[k]; ok {
+			continue
+		}
+		if v := wrpctx.Get(entry.Context, k); v != nil && v != "" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// withStaticFields returns a copy of the entry fields with the static fields added. An entry
+// field with the same key as a static field is kept under the key prefixed with `fields.`.
+func withStaticFields(data, static log.Fields) log.Fields {
+	fields := make(log.Fields, len(data)+len(static))
+	for k, v := range data {
+		if _, ok := static[k]; ok {
+			k = clashPrefix + k
+		}
+		fields[k] = v
+	}
+	for k, v := range static {
+		fields[k] = v
+	}
+	return fields
+}
+
+// AddStaticFields adds fields which are printed in every log entry of the default logger.
+func AddStaticFields(fields log.Fields) {
+	Default().AddStaticFields(fields)
+}
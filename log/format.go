@@ -0,0 +1,87 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Format is the output format of the log entries printed by the gokit formatter.
+type Format string
+
+const (
+	// FormatJSON prints each entry as a JSON object. This is the default format.
+	FormatJSON Format = "json"
+	// FormatText prints each entry as colourised and human readable text. This is meant
+	// for local development.
+	FormatText Format = "text"
+	// FormatLogfmt prints each entry as `key=value` pairs.
+	FormatLogfmt Format = "logfmt"
+)
+
+const (
+	// formatEnv is the environment variable which selects the output format.
+	formatEnv = "LOG_FORMAT"
+	// fieldMapEnv is the environment variable which renames the default JSON keys e.g
+	// `time=@timestamp,msg=message,level=severity`.
+	fieldMapEnv = "LOG_FIELD_MAP"
+)
+
+var defaultFieldMap = log.FieldMap{
+	log.FieldKeyTime: "timestamp",
+}
+
+// ParseFormat returns the Format for the passed name. Names are case-insensitive. An empty
+// name is parsed as FormatJSON. An error is returned if the name is not a known format.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimSpace(name))); f {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatText, FormatLogfmt:
+		return f, nil
+	default:
+		return FormatJSON, fmt.Errorf("unknown log format %q", name)
+	}
+}
+
+// ParseFieldMap parses a comma separated list of `key=name` pairs into a logrus.FieldMap.
+// Valid keys are `time`, `msg` and `level`. This is useful to match the keys expected by
+// log pipelines e.g `time=@timestamp,msg=message,level=severity`.
+func ParseFieldMap(s string) (log.FieldMap, error) {
+	fieldMap := log.FieldMap{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid field mapping %q", pair)
+		}
+
+		name := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "time":
+			fieldMap[log.FieldKeyTime] = name
+		case "msg":
+			fieldMap[log.FieldKeyMsg] = name
+		case "level":
+			fieldMap[log.FieldKeyLevel] = name
+		default:
+			return nil, fmt.Errorf("unknown field %q in field mapping", kv[0])
+		}
+	}
+	return fieldMap, nil
+}
+
+// mergeFieldMap returns a copy of the default field map overridden with the passed one.
+func mergeFieldMap(fieldMap log.FieldMap) log.FieldMap {
+	merged := log.FieldMap{}
+	for k, v := range defaultFieldMap {
+		merged[k] = v
+	}
+	for k, v := range fieldMap {
+		merged[k] = v
+	}
+	return merged
+}
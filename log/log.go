@@ -1,48 +1,132 @@
 package log
 
 import (
-	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-)
 
-var jsonFormatter = log.JSONFormatter{
-	TimestampFormat: time.RFC3339,
-	FieldMap: log.FieldMap{
-		log.FieldKeyTime: "timestamp",
-	},
-}
+	"github.com/wrapp/gokit/env"
+)
 
 type wrappFormatter struct {
-	service string
+	mu       sync.RWMutex
+	service  string
+	format   Format
+	fieldMap log.FieldMap
+	redactor *Redactor
+	fields   log.Fields
 }
 
-var formatter = &wrappFormatter{}
+// newFormatter creates a formatter for the service. The output format, the JSON keys, the
+// extra redacted keys and the default static fields are read from the environment variables.
+func newFormatter(service string) *wrappFormatter {
+	f := &wrappFormatter{
+		service:  service,
+		format:   FormatJSON,
+		fieldMap: defaultFieldMap,
+		redactor: NewRedactor(),
+		fields:   defaultStaticFields(),
+	}
 
-// Format formats the log entry in JSON. It also adds `service` key which contains the
-// name of the service. This is useful to distinguish logs per service when you have many
-// different services.
+	if format, err := ParseFormat(env.Get(formatEnv)); err == nil {
+		f.format = format
+	}
+	if fieldMap, err := ParseFieldMap(env.Get(fieldMapEnv)); err == nil {
+		f.fieldMap = mergeFieldMap(fieldMap)
+	}
+	for _, expr := range strings.Split(env.Get(redactKeysEnv), ",") {
+		if re, err := regexp.Compile(strings.TrimSpace(expr)); err == nil && expr != "" {
+			f.redactor.KeyPatterns = append(f.redactor.KeyPatterns, re)
+		}
+	}
+	return f
+}
+
+// Format formats the log entry in the configured output format. It also adds `service` key
+// which contains the name of the service. This is useful to distinguish logs per service when
+// you have many different services. The static fields e.g `hostname` or `version` are added
+// as well. If the entry has a field with the same key as a static field then the entry field
+// is printed with `fields.` prefix e.g `fields.service`. If the entry has a context, e.g
+// through WithContext, then `request_id`, `trace_id` and `span_id` are added from its wrpctx.
 // The `timestamp` contains the UTC time in `time.RFC3339` format. Message of the log is
-// contained in `msg` key.
-func (f wrappFormatter) Format(entry *log.Entry) ([]byte, error) {
-	e := entry.WithFields(log.Fields{
-		"service": f.service,
-	})
+// contained in `msg` key. The names of these keys can be changed for JSON output with
+// SetFieldMap.
+// Errors in the fields are printed as JSON objects with their message, type, chain of wrapped
+// errors and stacktrace. See WithStack for more information.
+// Sensitive data in the fields and the message is masked by the redactor before the entry is
+// serialised. See Redactor for more information.
+func (f *wrappFormatter) Format(entry *log.Entry) ([]byte, error) {
+	f.mu.RLock()
+	service, format, fieldMap, redactor := f.service, f.format, f.fieldMap, f.redactor
+	static := make(log.Fields, len(f.fields)+1)
+	for k, v := range f.fields {
+		static[k] = v
+	}
+	f.mu.RUnlock()
+
+	static["service"] = service
+	e := entry.WithFields(log.Fields{})
+	e.Data = withStaticFields(contextFields(entry), static)
+	if format == FormatJSON {
+		e.Data = errorFields(e.Data)
+	}
 
 	e.Time = time.Now().UTC()
 	e.Level = entry.Level
 	e.Message = entry.Message
-	return (&jsonFormatter).Format(e)
+	if redactor != nil {
+		e.Data = redactor.Redact(e.Data)
+		e.Message = redactor.RedactString(e.Message)
+	}
+	return encoder(format, fieldMap).Format(e)
 }
 
-// SetServiceName sets the name of the service in the formatter which is used in every
+// encoder returns the logrus formatter which serialises the entry in the passed format.
+func encoder(format Format, fieldMap log.FieldMap) log.Formatter {
+	switch format {
+	case FormatText:
+		return &log.TextFormatter{
+			ForceColors:     true,
+			FullTimestamp:   true,
+			TimestampFormat: time.RFC3339,
+		}
+	case FormatLogfmt:
+		return &log.TextFormatter{
+			DisableColors:   true,
+			FullTimestamp:   true,
+			TimestampFormat: time.RFC3339,
+		}
+	default:
+		return &log.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+			FieldMap:        fieldMap,
+		}
+	}
+}
+
+// SetServiceName sets the name of the service in the default logger which is used in every
 // log entry it prints.
 func SetServiceName(service string) {
-	formatter.service = service
+	Default().SetServiceName(service)
+}
+
+// SetFormat sets the output format of the default logger. See Format for the available formats.
+func SetFormat(format Format) {
+	Default().SetFormat(format)
+}
+
+// SetFieldMap sets the names of the `time`, `msg` and `level` keys in the JSON output of the
+// default logger. Keys which are not present in the map keep their default names i.e
+// `timestamp`, `msg` and `level`.
+func SetFieldMap(fieldMap log.FieldMap) {
+	Default().SetFieldMap(fieldMap)
 }
 
-func init() {
-	log.SetFormatter(formatter)
-	log.SetOutput(os.Stdout)
+// SetRedactor sets the redactor of the default logger which masks sensitive data before log
+// entries are serialised. Passing nil disables redaction.
+func SetRedactor(r *Redactor) {
+	Default().SetRedactor(r)
 }
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func formatEntry(t *testing.T, f *wrappFormatter) string {
+	entry := log.NewEntry(log.New()).WithField("key", "value")
+	entry.Message = "hello"
+	entry.Level = log.InfoLevel
+	b, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format returned error %q", err)
+	}
+	return string(b)
+}
+
+func TestJSONFormat(t *testing.T) {
+	t.Parallel()
+	f := &wrappFormatter{service: "svc", format: FormatJSON, fieldMap: defaultFieldMap}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(formatEntry(t, f)), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", err)
+	}
+	for _, k := range []string{"timestamp", "msg", "level", "service", "key"} {
+		if _, ok := m[k]; !ok {
+			t.Errorf("Expected key %q in %v", k, m)
+		}
+	}
+	if m["service"] != "svc" {
+		t.Errorf("service = %q wanted \"svc\"", m["service"])
+	}
+}
+
+func TestJSONFieldMap(t *testing.T) {
+	t.Parallel()
+	fm, err := ParseFieldMap("time=@timestamp, msg=message,level=severity")
+	if err != nil {
+		t.Fatalf("ParseFieldMap returned error %q", err)
+	}
+	f := &wrappFormatter{service: "svc", format: FormatJSON, fieldMap: mergeFieldMap(fm)}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(formatEntry(t, f)), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", err)
+	}
+	for _, k := range []string{"@timestamp", "message", "severity", "service"} {
+		if _, ok := m[k]; !ok {
+			t.Errorf("Expected key %q in %v", k, m)
+		}
+	}
+
+	if _, err := ParseFieldMap("caller=func"); err == nil {
+		t.Errorf("Expected error for unknown field")
+	}
+}
+
+func TestLogfmtFormat(t *testing.T) {
+	t.Parallel()
+	f := &wrappFormatter{service: "svc", format: FormatLogfmt, fieldMap: defaultFieldMap}
+
+	out := formatEntry(t, f)
+	for _, s := range []string{"level=info", "msg=hello", "service=svc", "key=value"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected %q in %q", s, out)
+		}
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	t.Parallel()
+	f := &wrappFormatter{service: "svc", format: FormatText, fieldMap: defaultFieldMap}
+
+	out := formatEntry(t, f)
+	if !strings.Contains(out, "\x1b[") {
+		t.Errorf("Expected colourised output got %q", out)
+	}
+	if !strings.Contains(out, "svc") {
+		t.Errorf("Expected service name in %q", out)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	t.Parallel()
+	for in, want := range map[string]Format{"": FormatJSON, "TEXT": FormatText, "logfmt": FormatLogfmt} {
+		if f, err := ParseFormat(in); err != nil || f != want {
+			t.Errorf("ParseFormat(%q) = %q, %v wanted %q", in, f, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("Expected error for unknown format")
+	}
+}
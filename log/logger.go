@@ -0,0 +1,158 @@
+package log
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/env"
+)
+
+// Logger is a logrus logger which uses the gokit formatter. Each Logger has its own service
+// name, output, level and static fields so that it does not interfere with other loggers or
+// with the global logrus logger. All the logrus methods e.g Info or WithField are available on
+// the Logger. A Logger is safe for concurrent use.
+type Logger struct {
+	*log.Logger
+	formatter *wrappFormatter
+
+	mu    sync.Mutex
+	async *AsyncWriter
+}
+
+var std = New(env.ServiceName())
+
+// New creates a new Logger for the service. The logger prints on stdout at info level. The
+// output format, the JSON keys and the extra redacted keys are read from the environment
+// variables. See SetFormat, SetFieldMap and SetRedactor for more information.
+func New(service string) *Logger {
+	f := newFormatter(service)
+	l := log.New()
+	l.Out = os.Stdout
+	l.Formatter = f
+	return &Logger{Logger: l, formatter: f}
+}
+
+// Default returns the default Logger. The service name of the default logger is read from
+// `SERVICE_NAME` environment variable. The default logger is used by the kit and the
+// middlewares when no other logger is passed.
+func Default() *Logger {
+	return std
+}
+
+// SetGlobal makes the global logrus logger print through the passed Logger. It sets the
+// formatter, output and level of the global logger. Changes to the service name, format and
+// static fields of the Logger are reflected in the global logger but changes to its output
+// and level are not. The default slog logger is set to print through the Logger as well, see
+// NewSlogHandler. This is opt-in, gokit never changes the global loggers by itself.
+func SetGlobal(l *Logger) {
+	log.SetFormatter(l.formatter)
+	log.SetOutput(l.Out)
+	log.SetLevel(l.GetLevel())
+	slog.SetDefault(l.Slog())
+}
+
+// ServiceName returns the name of the service which is printed in every log entry.
+func (l *Logger) ServiceName() string {
+	l.formatter.mu.RLock()
+	defer l.formatter.mu.RUnlock()
+	return l.formatter.service
+}
+
+// SetServiceName sets the name of the service which is printed in every log entry.
+func (l *Logger) SetServiceName(service string) {
+	l.formatter.mu.Lock()
+	defer l.formatter.mu.Unlock()
+	l.formatter.service = service
+}
+
+// SetFormat sets the output format. See Format for the available formats.
+func (l *Logger) SetFormat(format Format) {
+	l.formatter.mu.Lock()
+	defer l.formatter.mu.Unlock()
+	l.formatter.format = format
+}
+
+// SetFieldMap sets the names of the `time`, `msg` and `level` keys in the JSON output. Keys
+// which are not present in the map keep their default names i.e `timestamp`, `msg` and `level`.
+func (l *Logger) SetFieldMap(fieldMap log.FieldMap) {
+	l.formatter.mu.Lock()
+	defer l.formatter.mu.Unlock()
+	l.formatter.fieldMap = mergeFieldMap(fieldMap)
+}
+
+// SetRedactor sets the redactor which masks sensitive data before log entries are serialised.
+// Passing nil disables redaction. The redactor should not be modified after it is set.
+func (l *Logger) SetRedactor(r *Redactor) {
+	l.formatter.mu.Lock()
+	defer l.formatter.mu.Unlock()
+	l.formatter.redactor = r
+}
+
+// AddStaticFields adds fields which are printed in every log entry of the logger. By default
+// `hostname`, `pod`, `region`, `environment` and `version` are discovered from the environment
+// variables `HOSTNAME`, `POD_NAME`, `REGION`, `ENVIRONMENT` and `VERSION`. The hostname
+// falls back to the hostname reported by the OS. Static fields are never overwritten by the
+// fields of an entry.
+func (l *Logger) AddStaticFields(fields log.Fields) {
+	l.formatter.mu.Lock()
+	defer l.formatter.mu.Unlock()
+	for k, v := range fields {
+		l.formatter.fields[k] = v
+	}
+}
+
+// SetAsync makes the logger write through an AsyncWriter which wraps the current output. The
+// queue holds at most `size` entries and `policy` decides what happens when it is full. Fatal
+// and panic entries are always written synchronously. The returned writer can be used to read
+// the number of dropped entries. Calling SetAsync again closes the previous writer and replaces
+// it. Close should be called before the program exits.
+func (l *Logger) SetAsync(size int, policy OverflowPolicy) *AsyncWriter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := l.Out
+	if l.async != nil {
+		out = l.async.out
+		l.async.Close()
+		hooks := log.LevelHooks{}
+		for level, levelHooks := range l.Hooks {
+			for _, h := range levelHooks {
+				if h != log.Hook(l.async) {
+					hooks[level] = append(hooks[level], h)
+				}
+			}
+		}
+		l.ReplaceHooks(hooks)
+	}
+	w := NewAsyncWriter(out, size, policy)
+	l.SetOutput(w)
+	l.AddHook(w)
+	l.async = w
+	return w
+}
+
+// Flush writes all the buffered entries if the logger writes asynchronously. See SetAsync.
+func (l *Logger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.async == nil {
+		return nil
+	}
+	return l.async.Flush()
+}
+
+// Close flushes the buffered entries and stops the asynchronous writer if the logger writes
+// asynchronously. Entries logged afterwards are written synchronously. See SetAsync.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.async == nil {
+		return nil
+	}
+	return l.async.Close()
+}
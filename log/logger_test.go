@@ -0,0 +1,109 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+func TestLoggerFields(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+	l.AddStaticFields(log.Fields{"region": "eu-west-1"})
+	l.Info("hello")
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", buf.String())
+	}
+	if m["service"] != "svc" || m["region"] != "eu-west-1" || m["msg"] != "hello" {
+		t.Errorf("Unexpected log entry %v", m)
+	}
+}
+
+func TestLoggerIsolated(t *testing.T) {
+	t.Parallel()
+
+	if _, ok := log.StandardLogger().Formatter.(*wrappFormatter); ok {
+		t.Errorf("Expected global logrus formatter to be untouched")
+	}
+}
+
+func TestLoggerConcurrentServiceName(t *testing.T) {
+	t.Parallel()
+
+	l := New("svc")
+	l.SetOutput(ioutil.Discard)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			l.SetServiceName("other")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			l.Info("hello")
+		}
+	}()
+	wg.Wait()
+}
+
+func TestLoggerStaticFieldClash(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+	l.AddStaticFields(log.Fields{"version": "1.2.3"})
+	l.WithFields(log.Fields{"version": "other", "service": "fake"}).Info("hello")
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", buf.String())
+	}
+	if m["version"] != "1.2.3" || m["fields.version"] != "other" {
+		t.Errorf("Expected static version to be kept got %v", m)
+	}
+	if m["service"] != "svc" || m["fields.service"] != "fake" {
+		t.Errorf("Expected service to be kept got %v", m)
+	}
+	if m["hostname"] == nil {
+		t.Errorf("Expected hostname to be discovered got %v", m)
+	}
+}
+
+func TestLoggerContextFields(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+
+	ctx := wrpctx.New(context.Background())
+	wrpctx.Set(ctx, "request_id", "rid")
+	wrpctx.Set(ctx, "trace_id", "4bf92f3577b34da6a3ce929d0e0e4736")
+	wrpctx.Set(ctx, "span_id", "00f067aa0ba902b7")
+	l.WithContext(ctx).Info("hello")
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", buf.String())
+	}
+	if m["request_id"] != "rid" || m["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || m["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("Expected context fields got %v", m)
+	}
+}
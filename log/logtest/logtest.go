@@ -0,0 +1,204 @@
+// logtest provides helpers to assert on the log entries printed by a gokit logger in tests.
+// A Recorder creates a Logger which is scoped to a single test. The Logger prints the entries
+// with the gokit JSON formatter into the Recorder, which parses them so that they can be
+// inspected or asserted on. Every test has its own Recorder so it works with parallel tests.
+
+// Pass the Logger of the Recorder to the code under test e.g:
+/*
+	rec := logtest.New(t)
+	srv := kit.SimpleServiceWithLogger(handler, rec.Logger)
+	// serve a request
+	rec.AssertLogged(logrus.ErrorLevel, "PANIC! in http handler", nil)
+*/
+package logtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	kitlog "github.com/wrapp/gokit/log"
+)
+
+// Entry is a captured log entry. Fields contains all the keys of the entry except `level`,
+// `msg`, `timestamp` and `service`, with the values as they were decoded from JSON.
+type Entry struct {
+	Level   log.Level
+	Message string
+	Service string
+	Time    time.Time
+	Fields  map[string]interface{}
+}
+
+// Recorder captures the log entries of its Logger. It is safe for concurrent use.
+type Recorder struct {
+	Logger *kitlog.Logger
+
+	t       testing.TB
+	mu      sync.Mutex
+	buf     []byte
+	entries []Entry
+}
+
+// New creates a Recorder and a Logger which prints into it. The Logger uses `test` as the name
+// of the service, the JSON format and debug level.
+func New(t testing.TB) *Recorder {
+	r := &Recorder{t: t}
+	r.Logger = kitlog.New("test")
+	r.Logger.SetFormat(kitlog.FormatJSON)
+	r.Logger.SetFieldMap(nil)
+	r.Logger.SetLevel(log.DebugLevel)
+	r.Logger.SetOutput(r)
+	return r
+}
+
+// Write parses the entries printed by the Logger. Each entry is expected to be a JSON object
+// on a single line. The test fails if an entry cannot be parsed.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf = append(r.buf, p...)
+	for {
+		i := bytes.IndexByte(r.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := r.buf[:i]
+		r.buf = r.buf[i+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		e, err := parse(line)
+		if err != nil {
+			r.t.Errorf("logtest: cannot parse log entry %q: %s", line, err)
+			continue
+		}
+		r.entries = append(r.entries, e)
+	}
+	return len(p), nil
+}
+
+// Entries returns a copy of the captured entries in the order they were printed.
+func (r *Recorder) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry{}, r.entries...)
+}
+
+// Reset removes all the captured entries.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+}
+
+// Find returns the captured entries which have the level, the message and contain all the
+// passed fields. Values of the fields are compared after they are converted to JSON so e.g an
+// int matches the float64 which was decoded from the entry.
+func (r *Recorder) Find(level log.Level, msg string, fields map[string]interface{}) []Entry {
+	want := normalize(fields)
+
+	var found []Entry
+	for _, e := range r.Entries() {
+		if e.Level == level && e.Message == msg && containsFields(e, want) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// AssertLogged fails the test if no entry with the level, the message and the fields was
+// captured. See Find for how the fields are matched. It returns whether the assertion passed.
+func (r *Recorder) AssertLogged(level log.Level, msg string, fields map[string]interface{}) bool {
+	r.t.Helper()
+	if len(r.Find(level, msg, fields)) > 0 {
+		return true
+	}
+	r.t.Errorf("logtest: no %s entry %q with fields %v in:\n%s", level, msg, fields, r.dump())
+	return false
+}
+
+// AssertNotLogged fails the test if an entry with the level and the message was captured. It
+// returns whether the assertion passed.
+func (r *Recorder) AssertNotLogged(level log.Level, msg string) bool {
+	r.t.Helper()
+	if len(r.Find(level, msg, nil)) == 0 {
+		return true
+	}
+	r.t.Errorf("logtest: unexpected %s entry %q in:\n%s", level, msg, r.dump())
+	return false
+}
+
+func (r *Recorder) dump() string {
+	var lines []string
+	for _, e := range r.Entries() {
+		lines = append(lines, fmt.Sprintf("\t%s %q %v", e.Level, e.Message, e.Fields))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parse(line []byte) (Entry, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(line, &m); err != nil {
+		return Entry{}, err
+	}
+
+	levelStr, _ := m["level"].(string)
+	level, err := log.ParseLevel(levelStr)
+	if err != nil {
+		return Entry{}, err
+	}
+	ts, _ := m["timestamp"].(string)
+	tm, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{Level: level, Time: tm, Fields: map[string]interface{}{}}
+	e.Message, _ = m["msg"].(string)
+	e.Service, _ = m["service"].(string)
+	for k, v := range m {
+		switch k {
+		case "level", "msg", "timestamp", "service":
+		default:
+			e.Fields[k] = v
+		}
+	}
+	return e, nil
+}
+
+// normalize converts the values to the types they have when they are decoded from JSON.
+func normalize(fields map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		var n interface{}
+		if b, err := json.Marshal(v); err == nil && json.Unmarshal(b, &n) == nil {
+			normalized[k] = n
+			continue
+		}
+		normalized[k] = v
+	}
+	return normalized
+}
+
+func containsFields(e Entry, fields map[string]interface{}) bool {
+	for k, v := range fields {
+		got, ok := e.Fields[k]
+		if k == "service" {
+			got, ok = e.Service, true
+		}
+		if !ok || !reflect.DeepEqual(got, v) {
+			return false
+		}
+	}
+	return true
+}
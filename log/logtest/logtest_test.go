@@ -0,0 +1,52 @@
+package logtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/internal/testutil"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	rec := New(t)
+	rec.Logger.WithFields(log.Fields{"order": 42, "state": "paid"}).Info("Order updated")
+	rec.Logger.WithError(errors.New("timeout")).Warn("Retrying")
+
+	entries := rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries got %d", len(entries))
+	}
+	if e := entries[0]; e.Service != "test" || e.Time.IsZero() || time.Since(e.Time) > time.Minute {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	rec.AssertLogged(log.InfoLevel, "Order updated", map[string]interface{}{"order": 42, "service": "test"})
+	rec.AssertLogged(log.WarnLevel, "Retrying", nil)
+	rec.AssertNotLogged(log.ErrorLevel, "Order updated")
+
+	if found := rec.Find(log.InfoLevel, "Order updated", map[string]interface{}{"order": 43}); len(found) != 0 {
+		t.Errorf("Expected no entries got %v", found)
+	}
+
+	rec.Reset()
+	if len(rec.Entries()) != 0 {
+		t.Errorf("Expected no entries after Reset")
+	}
+}
+
+func TestRecorderAssertFails(t *testing.T) {
+	t.Parallel()
+
+	ft := &testutil.FakeT{TB: t}
+	rec := New(ft)
+	rec.Logger.Info("hello")
+
+	if rec.AssertLogged(log.InfoLevel, "bye", nil) || !ft.Failed() {
+		t.Errorf("Expected assertion to fail")
+	}
+}
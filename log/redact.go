@@ -0,0 +1,196 @@
+package log
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// redactKeysEnv is the environment variable which contains a comma separated list of extra key
+// patterns that should be redacted.
+const redactKeysEnv = "LOG_REDACT_KEYS"
+
+// maxRedactDepth is the maximum depth of nested values which are walked. Values nested deeper
+// than this are masked completely.
+const maxRedactDepth = 32
+
+// ValuePattern matches sensitive parts of string values. Validate is optional and can be used to
+// reject false positives of Regexp e.g to verify the checksum of a card number.
+type ValuePattern struct {
+	Regexp   *regexp.Regexp
+	Validate func(string) bool
+}
+
+// Redactor masks sensitive data in log entries. Values of the fields whose key matches one of
+// KeyPatterns are masked completely. Parts of string values which match one of ValuePatterns
+// are masked wherever they appear. Nested maps, slices and structs are walked as well.
+type Redactor struct {
+	KeyPatterns   []*regexp.Regexp
+	ValuePatterns []ValuePattern
+	Mask          string
+}
+
+// DefaultKeyPatterns matches keys which usually contain credentials e.g `Authorization`,
+// `password`, `access_token` or `api_key`.
+var DefaultKeyPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)authorization|cookie|passw(or)?d|secret|token|api[-_]?key|credential`),
+}
+
+// DefaultValuePatterns matches JWTs, bearer tokens, card numbers and email addresses.
+var DefaultValuePatterns = []ValuePattern{
+	{Regexp: regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	{Regexp: regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/-]+=*`)},
+	{Regexp: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Validate: luhn},
+	{Regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+}
+
+// NewRedactor creates a Redactor with the default key and value patterns. Redacted values are
+// replaced with `[REDACTED]`.
+func NewRedactor() *Redactor {
+	return &Redactor{
+		KeyPatterns:   append([]*regexp.Regexp{}, DefaultKeyPatterns...),
+		ValuePatterns: append([]ValuePattern{}, DefaultValuePatterns...),
+		Mask:          "[REDACTED]",
+	}
+}
+
+// AddKeyPattern adds a regular expression which matches the keys that should be redacted. It
+// panics if the expression cannot be compiled.
+func (r *Redactor) AddKeyPattern(expr string) {
+	r.KeyPatterns = append(r.KeyPatterns, regexp.MustCompile(expr))
+}
+
+// AddValuePattern adds a regular expression which matches the parts of string values that
+// should be redacted. It panics if the expression cannot be compiled.
+func (r *Redactor) AddValuePattern(expr string) {
+	r.ValuePatterns = append(r.ValuePatterns, ValuePattern{Regexp: regexp.MustCompile(expr)})
+}
+
+// Redact returns a copy of the fields with sensitive values masked. The passed fields are not
+// modified.
+func (r *Redactor) Redact(fields log.Fields) log.Fields {
+	redacted := make(log.Fields, len(fields))
+	for k, v := range fields {
+		if r.matchKey(k) {
+			redacted[k] = r.Mask
+			continue
+		}
+		redacted[k] = r.redactValue(reflect.ValueOf(v), 0)
+	}
+	return redacted
+}
+
+// RedactString masks the parts of the string which match any of the value patterns.
+func (r *Redactor) RedactString(s string) string {
+	for _, p := range r.ValuePatterns {
+		s = p.Regexp.ReplaceAllStringFunc(s, func(m string) string {
+			if p.Validate != nil && !p.Validate(m) {
+				return m
+			}
+			return r.Mask
+		})
+	}
+	return s
+}
+
+func (r *Redactor) matchKey(key string) bool {
+	for _, p := range r.KeyPatterns {
+		if p.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue walks the value and returns a copy of it with sensitive data masked. Maps and
+// structs are returned as map[string]interface{} and slices as []interface{}.
+func (r *Redactor) redactValue(v reflect.Value, depth int) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if depth > maxRedactDepth {
+		return r.Mask
+	}
+
+	switch i := v.Interface().(type) {
+	case string:
+		return r.RedactString(i)
+	case error:
+		return r.RedactString(i.Error())
+	case json.Marshaler, encoding.TextMarshaler:
+		return r.redactMarshaled(i, depth)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return r.redactValue(v.Elem(), depth+1)
+	case reflect.String:
+		return r.RedactString(v.String())
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			k := fmt.Sprint(key.Interface())
+			if r.matchKey(k) {
+				m[k] = r.Mask
+				continue
+			}
+			m[k] = r.redactValue(v.MapIndex(key), depth+1)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return r.Mask
+		}
+		s := make([]interface{}, v.Len())
+		for j := 0; j < v.Len(); j++ {
+			s[j] = r.redactValue(v.Index(j), depth+1)
+		}
+		return s
+	case reflect.Struct:
+		return r.redactMarshaled(v.Interface(), depth)
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return fmt.Sprintf("%T", v.Interface())
+	default:
+		return v.Interface()
+	}
+}
+
+// redactMarshaled converts the value through its JSON representation so that struct tags and
+// custom marshalers are honoured, and redacts the result.
+func (r *Redactor) redactMarshaled(i interface{}, depth int) interface{} {
+	b, err := json.Marshal(i)
+	if err != nil {
+		return r.RedactString(fmt.Sprintf("%+v", i))
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return r.RedactString(string(b))
+	}
+	return r.redactValue(reflect.ValueOf(decoded), depth+1)
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by card numbers.
+func luhn(s string) bool {
+	sum, double := 0, false
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,219 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// slogHandler is an slog.Handler which prints the records through a Logger.
+type slogHandler struct {
+	logger *Logger
+	fields log.Fields
+	groups []string
+}
+
+// NewSlogHandler returns an slog.Handler which prints the records through the passed Logger.
+// The records go through the same formatter, output and level as the entries logged with
+// logrus so both produce the same JSON and interleave consistently. Attributes are added as
+// fields, groups become nested objects. The `request_id`, `trace_id` and `span_id` of the
+// wrpctx of the context passed to the slog methods are added as for logrus entries.
+func NewSlogHandler(l *Logger) slog.Handler {
+	return &slogHandler{logger: l, fields: log.Fields{}}
+}
+
+// Slog returns an slog.Logger which prints through the Logger. See NewSlogHandler.
+func (l *Logger) Slog() *slog.Logger {
+	return slog.New(NewSlogHandler(l))
+}
+
+// Enabled reports whether the logger prints records at the level.
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.GetLevel() >= logrusLevel(level)
+}
+
+// Handle prints the record through the logger.
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := log.Fields(copyFields(h.fields))
+	attrs := fields
+	if len(h.groups) > 0 {
+		attrs = groupFields(fields, h.groups)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(attrs, a)
+		return true
+	})
+
+	entry := h.logger.WithContext(ctx).WithFields(fields)
+	entry.Time = r.Time
+	switch level := logrusLevel(r.Level); level {
+	case log.TraceLevel:
+		entry.Trace(r.Message)
+	case log.DebugLevel:
+		entry.Debug(r.Message)
+	case log.InfoLevel:
+		entry.Info(r.Message)
+	case log.WarnLevel:
+		entry.Warn(r.Message)
+	default:
+		entry.Error(r.Message)
+	}
+	return nil
+}
+
+// WithAttrs returns a handler which adds the attributes to every record.
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := h.clone()
+	target := c.fields
+	if len(c.groups) > 0 {
+		target = groupFields(c.fields, c.groups)
+	}
+	for _, a := range attrs {
+		addAttr(target, a)
+	}
+	return c
+}
+
+// WithGroup returns a handler which nests the attributes of every record in the group.
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := h.clone()
+	c.groups = append(c.groups, name)
+	return c
+}
+
+func (h *slogHandler) clone() *slogHandler {
+	return &slogHandler{
+		logger: h.logger,
+		fields: copyFields(h.fields),
+		groups: append([]string{}, h.groups...),
+	}
+}
+
+// groupFields returns the map of the innermost group, creating the groups which do not exist.
+func groupFields(fields map[string]interface{}, groups []string) map[string]interface{} {
+	m := fields
+	for _, g := range groups {
+		next, ok := m[g].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[g] = next
+		}
+		m = next
+	}
+	return m
+}
+
+// copyFields returns a deep copy of the fields so that nested groups are not shared.
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if m, ok := v.(map[string]interface{}); ok {
+			v = copyFields(m)
+		}
+		c[k] = v
+	}
+	return c
+}
+
+func addAttr(m map[string]interface{}, a slog.Attr) {
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	v := a.Value.Resolve()
+	if v.Kind() != slog.KindGroup {
+		m[a.Key] = v.Any()
+		return
+	}
+
+	group := m
+	if a.Key != "" {
+		g, ok := m[a.Key].(map[string]interface{})
+		if !ok {
+			g = map[string]interface{}{}
+			m[a.Key] = g
+		}
+		group = g
+	}
+	for _, ga := range v.Group() {
+		addAttr(group, ga)
+	}
+}
+
+func logrusLevel(level slog.Level) log.Level {
+	switch {
+	case level < slog.LevelDebug:
+		return log.TraceLevel
+	case level < slog.LevelInfo:
+		return log.DebugLevel
+	case level < slog.LevelWarn:
+		return log.InfoLevel
+	case level < slog.LevelError:
+		return log.WarnLevel
+	default:
+		return log.ErrorLevel
+	}
+}
+
+func slogLevel(level log.Level) slog.Level {
+	switch level {
+	case log.TraceLevel:
+		return slog.LevelDebug - 4
+	case log.DebugLevel:
+		return slog.LevelDebug
+	case log.InfoLevel:
+		return slog.LevelInfo
+	case log.WarnLevel:
+		return slog.LevelWarn
+	case log.ErrorLevel, log.FatalLevel, log.PanicLevel:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// slogHook is a logrus hook which forwards the entries to an slog.Handler.
+type slogHook struct {
+	handler slog.Handler
+}
+
+// NewSlogHook returns a logrus hook which forwards every entry to the slog.Handler. It can be
+// added to a logrus logger, e.g the global one, so that libraries which log through logrus
+// print through slog. The fields of the entry are added as attributes in the order of their
+// keys. The hook must not forward to a handler from NewSlogHandler of the same logger, because
+// that would log every entry again.
+func NewSlogHook(h slog.Handler) log.Hook {
+	return &slogHook{handler: h}
+}
+
+// Levels returns all the levels.
+func (h *slogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire forwards the entry to the slog.Handler with the context of the entry.
+func (h *slogHook) Fire(entry *log.Entry) error {
+	ctx := entry.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	level := slogLevel(entry.Level)
+	if !h.handler.Enabled(ctx, level) {
+		return nil
+	}
+
+	r := slog.NewRecord(entry.Time, level, entry.Message, 0)
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r.AddAttrs(slog.Any(k, entry.Data[k]))
+	}
+	return h.handler.Handle(ctx, r)
+}
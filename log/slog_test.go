@@ -0,0 +1,141 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+var timestampRe = regexp.MustCompile(`"timestamp":"[^"]*"`)
+
+func TestSlogHandlerSameShape(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+
+	l.WithFields(log.Fields{"order": 42, "state": "paid"}).Info("Order updated")
+	l.Slog().Info("Order updated", "order", 42, "state", "paid")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines got %q", buf.String())
+	}
+	logrusLine := timestampRe.ReplaceAllString(lines[0], "")
+	slogLine := timestampRe.ReplaceAllString(lines[1], "")
+	if logrusLine != slogLine {
+		t.Errorf("Expected same output got\n%s\n%s", logrusLine, slogLine)
+	}
+}
+
+func TestSlogHandlerContextAndGroups(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := New("svc")
+	l.SetOutput(&buf)
+	l.SetLevel(log.WarnLevel)
+
+	ctx := wrpctx.New(context.Background())
+	wrpctx.Set(ctx, "request_id", "rid")
+	wrpctx.Set(ctx, "tenant", "acme")
+
+	logger := l.Slog().With("user", "bob").WithGroup("http")
+	logger.InfoContext(ctx, "skipped")
+	logger.WarnContext(ctx, "slow request", "status", 200, slog.Group("timing", "ms", 1200))
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output is not a single JSON entry: %q", buf.String())
+	}
+	if m["request_id"] != "rid" || m["user"] != "bob" || m["level"] != "warning" || m["tenant"] != nil {
+		t.Errorf("Unexpected entry %v", m)
+	}
+	http, _ := m["http"].(map[string]interface{})
+	timing, _ := http["timing"].(map[string]interface{})
+	if http["status"] != float64(200) || timing["ms"] != float64(1200) {
+		t.Errorf("Unexpected group %v", m["http"])
+	}
+}
+
+func TestSlogHook(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	h := slog.NewJSONHandler(&buf, nil)
+
+	l := log.New()
+	l.Out = &bytes.Buffer{}
+	l.AddHook(NewSlogHook(h))
+	l.WithField("order", 42).Warn("forwarded")
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", buf.String())
+	}
+	if m["msg"] != "forwarded" || m["level"] != "WARN" || m["order"] != float64(42) {
+		t.Errorf("Unexpected entry %v", m)
+	}
+}
+
+func TestSlogHookContext(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	target := New("svc")
+	target.SetOutput(&buf)
+	target.SetLevel(log.TraceLevel)
+
+	l := log.New()
+	l.Out = &bytes.Buffer{}
+	l.SetLevel(log.TraceLevel)
+	l.AddHook(NewSlogHook(NewSlogHandler(target)))
+
+	ctx := wrpctx.New(context.Background())
+	wrpctx.Set(ctx, "request_id", "rid")
+	l.WithContext(ctx).Trace("forwarded")
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output is not JSON: %q", buf.String())
+	}
+	if m["request_id"] != "rid" || m["level"] != "trace" {
+		t.Errorf("Unexpected entry %v", m)
+	}
+}
+
+func TestSlogLevel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		level log.Level
+		want  slog.Level
+	}{
+		{log.TraceLevel, slog.LevelDebug - 4},
+		{log.DebugLevel, slog.LevelDebug},
+		{log.InfoLevel, slog.LevelInfo},
+		{log.WarnLevel, slog.LevelWarn},
+		{log.ErrorLevel, slog.LevelError},
+		{log.FatalLevel, slog.LevelError},
+		{log.PanicLevel, slog.LevelError},
+	}
+	for _, tt := range tests {
+		if got := slogLevel(tt.level); got != tt.want {
+			t.Errorf("Expected %v for %v got %v", tt.want, tt.level, got)
+		}
+		if tt.level >= log.ErrorLevel {
+			if got := logrusLevel(tt.want); got != tt.level {
+				t.Errorf("Expected %v for %v got %v", tt.level, tt.want, got)
+			}
+		}
+	}
+}
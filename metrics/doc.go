@@ -0,0 +1,5 @@
+// metrics package provides counters, gauges and histograms which are exposed in the Prometheus
+// text format. Metrics are registered in a Registry, usually the default one, and a metric can
+// have labels, e.g the host of a request. A Registry is an http.Handler which serves all its
+// metrics so it can be mounted on e.g `/metrics` of the router of the service.
+package metrics
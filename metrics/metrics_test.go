@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Number of requests.", "host", "code")
+	inflight := r.Gauge("requests_in_flight", "Number of requests in flight.")
+	duration := r.Histogram("request_duration_seconds", "Duration of requests.", []float64{0.5, 0.1}, "host")
+
+	requests.Inc("a", "200")
+	requests.Add(2, "a", "200")
+	requests.Inc(`b"\`, "500")
+	inflight.Add(3)
+	inflight.Add(-1)
+	duration.Observe(0.05, "a")
+	duration.Observe(0.2, "a")
+	duration.Observe(1, "a")
+
+	if v := requests.Value("a", "200"); v != 3 {
+		t.Errorf("Expected counter to be 3 got %v", v)
+	}
+	if r.Counter("requests_total", "", "host", "code").Value("a", "200") != 3 {
+		t.Errorf("Expected registering the counter again to return the existing one")
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# HELP request_duration_seconds Duration of requests.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{host="a",le="0.1"} 1
+request_duration_seconds_bucket{host="a",le="0.5"} 2
+request_duration_seconds_bucket{host="a",le="+Inf"} 3
+request_duration_seconds_sum{host="a"} 1.25
+request_duration_seconds_count{host="a"} 3
+# HELP requests_in_flight Number of requests in flight.
+# TYPE requests_in_flight gauge
+requests_in_flight 2
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{host="a",code="200"} 3
+requests_total{host="b\"\\",code="500"} 1
+`
+	if body := w.Body.String(); body != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("total", "", "host")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering another type to panic")
+		}
+	}()
+	r.Gauge("total", "", "host")
+}
@@ -0,0 +1,123 @@
+// baggagemw is a middleware which restores the baggage of the caller, values like the tenant-id
+// which flow across service hops, in the wrpctx of the request. Only the allowlisted Keys are
+// read, so wrpctxmw must be added before this middleware. The baggage is read from the W3C
+// `baggage` header and, if Prefix is set, from the headers with the prefix e.g
+// `X-Baggage-tenant_id`. The size of the baggage is limited to MaxEntries entries and MaxSize
+// bytes in both directions.
+package baggagemw
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+const baggageHeader = "baggage"
+
+const (
+	// MaxEntries is the maximum number of baggage entries which are read or sent.
+	MaxEntries = 64
+	// MaxSize is the maximum size in bytes of the baggage which is read or sent.
+	MaxSize = 8192
+)
+
+// BaggageHandler contains the allowlist of the baggage keys and the prefix of the baggage
+// headers. Only the W3C `baggage` header is used if Prefix is empty.
+type BaggageHandler struct {
+	Keys   []string
+	Prefix string
+}
+
+func (h BaggageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	wrpctx.SetBaggage(r.Context(), FromHeader(r.Header, h.Keys, h.Prefix), h.Keys...)
+	next(w, r)
+}
+
+// FromHeader returns the baggage of the allowlisted keys from the http.Header. The W3C
+// `baggage` header is read first and, if prefix is not empty, the headers with the prefix
+// override its values. Entries beyond MaxEntries or MaxSize are dropped.
+func FromHeader(h http.Header, keys []string, prefix string) map[string]string {
+	allowed := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		allowed[k] = true
+	}
+	baggage := map[string]string{}
+	size := 0
+	add := func(k, v string) {
+		if !allowed[k] || v == "" {
+			return
+		}
+		newSize := size + len(k) + len(v)
+		if old, ok := baggage[k]; ok {
+			newSize -= len(k) + len(old)
+		} else if len(baggage) >= MaxEntries {
+			return
+		}
+		if newSize > MaxSize {
+			return
+		}
+		size = newSize
+		baggage[k] = v
+	}
+
+	for _, line := range h.Values(baggageHeader) {
+		for _, member := range strings.Split(line, ",") {
+			// properties after `;` are not supported and are dropped
+			member = strings.SplitN(member, ";", 2)[0]
+			kv := strings.SplitN(member, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, err := url.PathUnescape(strings.TrimSpace(kv[1]))
+			if err != nil {
+				continue
+			}
+			add(strings.TrimSpace(kv[0]), v)
+		}
+	}
+	if prefix != "" {
+		for _, k := range keys {
+			if v, err := url.PathUnescape(h.Get(prefix + k)); err == nil {
+				add(k, v)
+			}
+		}
+	}
+	return baggage
+}
+
+// SetInHeader sets the baggage in the W3C `baggage` header and, if prefix is not empty, in a
+// header with the prefix for each key. The values are percent-encoded. Entries are added in
+// the order of their keys until MaxEntries or MaxSize is reached.
+func SetInHeader(h *http.Header, baggage map[string]string, prefix string) {
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	members := make([]string, 0, len(keys))
+	size := 0
+	for _, k := range keys {
+		member := k + "=" + url.PathEscape(baggage[k])
+		if len(members) >= MaxEntries || size+len(member)+1 > MaxSize {
+			break
+		}
+		size += len(member) + 1
+		members = append(members, member)
+		if prefix != "" {
+			h.Set(prefix+k, url.PathEscape(baggage[k]))
+		}
+	}
+	if len(members) > 0 {
+		h.Set(baggageHeader, strings.Join(members, ","))
+	}
+}
+
+// New creates a new BaggageHandler middleware which reads the passed keys from the W3C
+// `baggage` header.
+func New(keys ...string) BaggageHandler {
+	return BaggageHandler{Keys: keys}
+}
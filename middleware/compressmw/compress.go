@@ -0,0 +1,136 @@
+// compressmw is a middleware which accepts request bodies compressed with gzip or deflate, as
+// sent by the trace client when the compression of the requests is enabled. The body of a
+// request with a `Content-Encoding` of gzip or deflate is decompressed transparently for the
+// handler. Decompressed bodies are limited to MaxSize bytes to protect the service from
+// decompression bombs, reading beyond it fails with ErrTooLarge. Requests with another encoding
+// are responded with 415 Unsupported Media Type.
+package compressmw
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxSize is the maximum size of a decompressed body of the handler created by New.
+const DefaultMaxSize int64 = 64 << 20
+
+// AcceptEncoding are the encodings which are supported by NewReader.
+const AcceptEncoding = "gzip, deflate"
+
+var (
+	// ErrTooLarge is returned when a decompressed body is larger than its limit.
+	ErrTooLarge = errors.New("decompressed body is too large")
+	// ErrUnsupportedEncoding is returned by NewReader for encodings other than gzip or deflate.
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// DecompressHandler contains the maximum size of the decompressed request bodies, it is not
+// limited if MaxSize is 0.
+type DecompressHandler struct {
+	MaxSize int64
+}
+
+func (h DecompressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	encoding := r.Header.Get("Content-Encoding")
+	if encoding == "" || strings.EqualFold(encoding, "identity") || r.Body == nil || r.Body == http.NoBody {
+		next(w, r)
+		return
+	}
+	body, err := NewReader(r.Body, encoding, h.MaxSize)
+	if err != nil {
+		w.Header().Set("Accept-Encoding", AcceptEncoding)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	r.Body = body
+	r.ContentLength = -1
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	next(w, r)
+}
+
+// New creates a new DecompressHandler middleware which limits the decompressed bodies to
+// DefaultMaxSize.
+func New() DecompressHandler {
+	return DecompressHandler{MaxSize: DefaultMaxSize}
+}
+
+// NewReader returns a reader which decompresses the body with the encoding, gzip or deflate. It
+// fails with ErrTooLarge when more than maxSize bytes are read, the size is not limited if
+// maxSize is 0. Deflate is accepted with or without the zlib wrapper. The body is read lazily so
+// an empty body, e.g of a HEAD request, is not an error unless it is read. Closing the reader
+// closes the body.
+func NewReader(body io.ReadCloser, encoding string, maxSize int64) (io.ReadCloser, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	switch encoding {
+	case "gzip", "x-gzip", "deflate":
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+	return &reader{body: body, encoding: encoding, left: maxSize, limited: maxSize > 0}, nil
+}
+
+// reader decompresses the body on the first Read and counts the decompressed bytes.
+type reader struct {
+	body     io.ReadCloser
+	encoding string
+	r        io.Reader
+	err      error
+	left     int64
+	limited  bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.r == nil {
+		if r.r, r.err = r.decompressor(); r.err != nil {
+			return 0, r.err
+		}
+	}
+	if r.limited && int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+	n, err := r.r.Read(p)
+	if r.limited {
+		if int64(n) > r.left {
+			n, err = int(r.left), ErrTooLarge
+		}
+		r.left -= int64(n)
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *reader) decompressor() (io.Reader, error) {
+	if r.encoding != "deflate" {
+		return gzip.NewReader(r.body)
+	}
+	// Deflate should be wrapped by zlib but some servers send raw deflate.
+	br := bufio.NewReader(r.body)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func (r *reader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		c.Close()
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,124 @@
+// deadlinemw is a middleware which applies the deadline of the caller to the context.Context
+// of the request, so that a service stops working on requests the caller has already given up
+// on. The caller sends the time it has left in milliseconds in the `X-Request-Timeout-Ms`
+// header, which the trace client does for requests with a context deadline. The timeout is
+// capped by MaxTimeout. A request whose deadline already expired is not handled, it is
+// responded with StatusDeadlineExceeded and logged, as are requests whose deadline expires
+// before the handler responded.
+package deadlinemw
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/negroni"
+
+	kitlog "github.com/wrapp/gokit/log"
+)
+
+const timeoutHeader = "X-Request-Timeout-Ms"
+
+// StatusDeadlineExceeded is the status of the responses to requests whose deadline expired.
+const StatusDeadlineExceeded = http.StatusGatewayTimeout
+
+// DeadlineHandler contains the local limits of the timeout of the requests. MaxTimeout caps
+// the timeout of the caller. DefaultTimeout is applied to requests without a timeout, no
+// deadline is set if it is 0. Logger is used to log the requests whose deadline expired, the
+// default logger is used if it is nil.
+type DeadlineHandler struct {
+	MaxTimeout     time.Duration
+	DefaultTimeout time.Duration
+	Logger         *kitlog.Logger
+}
+
+// New creates a new DeadlineHandler middleware which caps the timeout of the caller at 60s,
+// the write timeout of the service.
+func New() DeadlineHandler {
+	return NewWithLogger(kitlog.Default())
+}
+
+// NewWithLogger creates a new DeadlineHandler middleware which logs with the passed logger.
+func NewWithLogger(logger *kitlog.Logger) DeadlineHandler {
+	return DeadlineHandler{MaxTimeout: 60 * time.Second, Logger: logger}
+}
+
+func (h DeadlineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	timeout, ok := TimeoutFromHeader(r.Header)
+	if !ok {
+		timeout = h.DefaultTimeout
+		if timeout <= 0 {
+			next(w, r)
+			return
+		}
+	}
+	if h.MaxTimeout > 0 && timeout > h.MaxTimeout {
+		timeout = h.MaxTimeout
+	}
+	if timeout <= 0 {
+		h.exceeded(w, r, timeout)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	rw, ok := w.(negroni.ResponseWriter)
+	if !ok {
+		rw = negroni.NewResponseWriter(w)
+	}
+	next(rw, r.WithContext(ctx))
+
+	if ctx.Err() == context.DeadlineExceeded && !rw.Written() {
+		h.exceeded(rw, r, timeout)
+	}
+}
+
+// exceeded responds with StatusDeadlineExceeded and logs the request.
+func (h DeadlineHandler) exceeded(w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	logger := h.Logger
+	if logger == nil {
+		logger = kitlog.Default()
+	}
+	logger.WithContext(r.Context()).WithFields(log.Fields{
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"timeout_ms": timeout.Milliseconds(),
+	}).Warn("request deadline exceeded")
+	http.Error(w, "deadline exceeded", StatusDeadlineExceeded)
+}
+
+// TimeoutFromHeader returns the timeout of the caller from the http.Header. The second return
+// value reports whether the header contained a valid timeout. The timeout can be 0 or negative
+// if the deadline of the caller already expired. Timeouts beyond the range of time.Duration are
+// clamped to it.
+func TimeoutFromHeader(h http.Header) (time.Duration, bool) {
+	v := h.Get(timeoutHeader)
+	if v == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch {
+	case ms > math.MaxInt64/int64(time.Millisecond):
+		return math.MaxInt64, true
+	case ms < math.MinInt64/int64(time.Millisecond):
+		return math.MinInt64, true
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// SetTimeoutInHeader sets the time left until the deadline of the context.Context in the
+// http.Header. The header is removed if the context has no deadline.
+func SetTimeoutInHeader(h *http.Header, ctx context.Context) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		h.Del(timeoutHeader)
+		return
+	}
+	h.Set(timeoutHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+}
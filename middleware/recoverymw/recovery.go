@@ -11,6 +11,8 @@ import (
 	"runtime"
 
 	log "github.com/sirupsen/logrus"
+
+	kitlog "github.com/wrapp/gokit/log"
 )
 
 // PanicHandlerFunc is a handler func which is called when middleware recovers from panic in
@@ -18,24 +20,41 @@ import (
 type PanicHandlerFunc func(interface{}, []byte)
 
 // RecoveryHandler is struct which holds the PanicHandlerFunc, size of the stacktrace, and
-// a field which tells whether to print the stack in the http.Response or not.
+// a field which tells whether to print the stack in the http.Response or not. Logger is used
+// to log errors which happen in PanicHandlerFunc. The default logger is used if it is nil.
 type RecoveryHandler struct {
 	PanicHandlerFunc PanicHandlerFunc
 	StackSize        int
 	PrintStack       bool
+	Logger           *kitlog.Logger
 }
 
 // New generates a default RecoveryHandler. By default panics are logged in stdout with a
 // stacktrace size of 50KB. Stacktrace is not logged to http.Response be default.
 func New() RecoveryHandler {
-	return RecoveryHandler{defaultPanicHandler, 1024 * 50, false}
+	return NewWithLogger(kitlog.Default())
+}
+
+// NewWithLogger generates a default RecoveryHandler which logs the panics with the passed
+// logger.
+func NewWithLogger(logger *kitlog.Logger) RecoveryHandler {
+	return RecoveryHandler{logPanic(logger), 1024 * 50, false, logger}
+}
+
+func logPanic(logger *kitlog.Logger) PanicHandlerFunc {
+	return func(err interface{}, stack []byte) {
+		logger.WithFields(log.Fields{
+			"panic": err,
+			"data":  log.Fields{"stacktrace": string(stack)},
+		}).Error("PANIC! in http handler")
+	}
 }
 
-func defaultPanicHandler(err interface{}, stack []byte) {
-	log.WithFields(log.Fields{
-		"panic": err,
-		"data":  log.Fields{"stacktrace": string(stack)},
-	}).Error("PANIC! in http handler")
+func (rec RecoveryHandler) logger() *kitlog.Logger {
+	if rec.Logger == nil {
+		return kitlog.Default()
+	}
+	return rec.Logger
 }
 
 func (rec RecoveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
@@ -46,7 +65,7 @@ func (rec RecoveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, nex
 
 			defer func() {
 				if recErr := recover(); recErr != nil {
-					log.WithField("panic", recErr).Error("Error in panic handler")
+					rec.logger().WithField("panic", recErr).Error("Error in panic handler")
 					http.Error(w, "", http.StatusInternalServerError)
 				}
 			}()
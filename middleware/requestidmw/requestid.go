@@ -7,6 +7,12 @@
 // In addition to http headers it also set the `request_id` field in the wrpctx.
 
 // A new id is generated if there was no header set in the incoming request.
+
+// The middleware also handles W3C Trace Context. The span of the caller is read from the
+// `traceparent` and `tracestate` headers, and optionally from the B3 headers. A child span is
+// created for each request and stored in the context.Context, see SpanFromCtx. Its trace-id and
+// span-id are set in the `trace_id` and `span_id` fields of the wrpctx and it is set in the
+// `traceresponse` header of response writer. A new trace is started if the caller sent no span.
 package requestidmw
 
 import (
@@ -28,16 +34,23 @@ type RequestIDFunc func() string
 var defGenFunc RequestIDFunc = generateUUID
 
 // XRequestIDHandler contains the generator function of request id. A custom generator
-// function can be used to generate new request ids.
+// function can be used to generate new request ids. If B3 is true then the B3 headers are
+// read, when there is no `traceparent` header, and set in the response.
 type XRequestIDHandler struct {
 	GenerateFunc RequestIDFunc
+	B3           bool
 }
 
 func (h XRequestIDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	id, _ := h.getOrGenerate(r)
 	w.Header().Set(headerKey, id)
 	wrpctx.Set(r.Context(), ctxKey, id)
-	next(w, r)
+
+	parent, _ := SpanFromHeader(r.Header, h.B3)
+	span := parent.Child()
+	respHeader := w.Header()
+	SetSpanInResponseHeader(&respHeader, span, h.B3)
+	next(w, r.WithContext(ContextWithSpan(r.Context(), span)))
 }
 
 func (h XRequestIDHandler) getOrGenerate(r *http.Request) (string, bool) {
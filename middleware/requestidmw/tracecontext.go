@@ -0,0 +1,227 @@
+package requestidmw
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+const (
+	traceParentHeader   = "traceparent"
+	traceStateHeader    = "tracestate"
+	traceResponseHeader = "traceresponse"
+	b3Header            = "b3"
+	b3TraceIDHeader     = "X-B3-TraceId"
+	b3SpanIDHeader      = "X-B3-SpanId"
+	b3ParentHeader      = "X-B3-ParentSpanId"
+	b3SampledHeader     = "X-B3-Sampled"
+
+	traceIDCtxKey = "trace_id"
+	spanIDCtxKey  = "span_id"
+	spanCtxKey    = "span_context"
+)
+
+var errInvalidTraceParent = errors.New("invalid traceparent")
+
+// SpanContext identifies a span in a distributed trace. TraceID is shared by all the spans of
+// a trace and is 32 lowercase hex characters. SpanID identifies the span and ParentID the span
+// of the caller, both are 16 lowercase hex characters. ParentID is empty for the first span of
+// a trace. TraceState contains the vendor specific `tracestate` header which is passed on as is.
+type SpanContext struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether the span context has a trace-id and a span-id.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+// Child returns a new span context in the same trace with a new span-id. The span-id of the
+// current span becomes the parent-id of the child. A new trace is started if the span context
+// is not valid.
+func (sc SpanContext) Child() SpanContext {
+	if !sc.IsValid() {
+		return SpanContext{TraceID: NewTraceID(), SpanID: NewSpanID(), Sampled: true}
+	}
+	return SpanContext{
+		TraceID:    sc.TraceID,
+		SpanID:     NewSpanID(),
+		ParentID:   sc.SpanID,
+		Sampled:    sc.Sampled,
+		TraceState: sc.TraceState,
+	}
+}
+
+// TraceParent returns the span context in the W3C `traceparent` header format.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID + "-" + sc.SpanID + "-" + flags
+}
+
+// ParseTraceParent parses a W3C `traceparent` header. The span-id of the header is the span of
+// the caller and is returned as SpanID.
+func ParseTraceParent(s string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, errInvalidTraceParent
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, errInvalidTraceParent
+	}
+
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isHex(parts[0], 2) || !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) ||
+		isZero(traceID) || isZero(spanID) {
+		return SpanContext{}, errInvalidTraceParent
+	}
+
+	b, _ := hex.DecodeString(flags)
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: b[0]&1 == 1}, nil
+}
+
+// SpanFromHeader returns the span context of the caller from the http.Header. The W3C
+// `traceparent` and `tracestate` headers are read first. If `b3` is true then the B3 headers,
+// either the single `b3` header or the `X-B3-*` headers, are read when there is no valid
+// `traceparent` header. The second return value reports whether a span context was found.
+func SpanFromHeader(h http.Header, b3 bool) (SpanContext, bool) {
+	if sc, err := ParseTraceParent(h.Get(traceParentHeader)); err == nil {
+		sc.TraceState = h.Get(traceStateHeader)
+		return sc, true
+	}
+	if !b3 {
+		return SpanContext{}, false
+	}
+
+	if single := h.Get(b3Header); single != "" {
+		parts := strings.Split(single, "-")
+		if len(parts) >= 2 {
+			sc := SpanContext{TraceID: padTraceID(parts[0]), SpanID: parts[1], Sampled: true}
+			if len(parts) >= 3 {
+				sc.Sampled = parts[2] == "1" || parts[2] == "d"
+			}
+			if isHex(sc.TraceID, 32) && isHex(sc.SpanID, 16) {
+				return sc, true
+			}
+		}
+	}
+
+	sc := SpanContext{
+		TraceID: padTraceID(h.Get(b3TraceIDHeader)),
+		SpanID:  h.Get(b3SpanIDHeader),
+		Sampled: h.Get(b3SampledHeader) != "0",
+	}
+	if isHex(sc.TraceID, 32) && isHex(sc.SpanID, 16) {
+		return sc, true
+	}
+	return SpanContext{}, false
+}
+
+// SetSpanInHeader sets the span context in the W3C `traceparent` and `tracestate` headers. If
+// `b3` is true then the `X-B3-*` headers are set as well.
+func SetSpanInHeader(h *http.Header, sc SpanContext, b3 bool) {
+	h.Set(traceParentHeader, sc.TraceParent())
+	if sc.TraceState != "" {
+		h.Set(traceStateHeader, sc.TraceState)
+	}
+	if b3 {
+		setB3(h, sc)
+	}
+}
+
+// SetSpanInResponseHeader sets the span context of the server in the W3C `traceresponse`
+// header of a response. If `b3` is true then the `X-B3-*` headers are set as well.
+func SetSpanInResponseHeader(h *http.Header, sc SpanContext, b3 bool) {
+	h.Set(traceResponseHeader, sc.TraceParent())
+	if b3 {
+		setB3(h, sc)
+	}
+}
+
+func setB3(h *http.Header, sc SpanContext) {
+	h.Set(b3TraceIDHeader, sc.TraceID)
+	h.Set(b3SpanIDHeader, sc.SpanID)
+	if sc.ParentID != "" {
+		h.Set(b3ParentHeader, sc.ParentID)
+	}
+	sampled := "0"
+	if sc.Sampled {
+		sampled = "1"
+	}
+	h.Set(b3SampledHeader, sampled)
+}
+
+// ContextWithSpan returns a context.Context which contains the span context. The trace-id and
+// the span-id are also set in the `trace_id` and `span_id` fields of the wrpctx so that they
+// are logged together with the other wrpctx fields.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	wrpctx.Set(ctx, traceIDCtxKey, sc.TraceID)
+	wrpctx.Set(ctx, spanIDCtxKey, sc.SpanID)
+	return wrpctx.NewWithValue(ctx, spanCtxKey, sc)
+}
+
+// SpanFromCtx returns the span context from a context.Context. If there is no span context
+// then an empty SpanContext is returned, which is not valid.
+func SpanFromCtx(ctx context.Context) SpanContext {
+	if sc, ok := wrpctx.GetCtxValue(ctx, spanCtxKey).(SpanContext); ok {
+		return sc
+	}
+	traceID, _ := wrpctx.Get(ctx, traceIDCtxKey).(string)
+	spanID, _ := wrpctx.Get(ctx, spanIDCtxKey).(string)
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true}
+}
+
+// NewTraceID generates a random trace-id.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewSpanID generates a random span-id.
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	for {
+		rand.Read(b)
+		if s := hex.EncodeToString(b); !isZero(s) {
+			return s
+		}
+	}
+}
+
+// padTraceID pads 64 bit B3 trace-ids to 128 bits.
+func padTraceID(id string) string {
+	if len(id) == 16 {
+		return strings.Repeat("0", 16) + id
+	}
+	return id
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
@@ -0,0 +1,64 @@
+// spanmw is a middleware which records a span for each incoming request. The span continues the
+// span context which was created by requestidmw, so it must be added after requestidmw. The span
+// carries the timing, the method, path and status of the request and is exported by the
+// tracer. Requests which respond with 5xx are marked as errors. The span is stored in the
+// context.Context and can be read with trace.SpanFromContext to add attributes or errors.
+package spanmw
+
+import (
+	"net/http"
+
+	"github.com/urfave/negroni"
+
+	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/trace"
+)
+
+// SpanHandler contains the tracer which records the spans. The default tracer is used if it
+// is nil.
+type SpanHandler struct {
+	Tracer *trace.Tracer
+}
+
+func (h SpanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	tracer := h.Tracer
+	if tracer == nil {
+		tracer = trace.DefaultTracer()
+	}
+
+	sc := requestidmw.SpanFromCtx(r.Context())
+	if !sc.IsValid() {
+		sc = sc.Child()
+	}
+	span := tracer.Start("HTTP "+r.Method, trace.SpanKindServer, sc)
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.Path)
+	span.SetAttribute("http.user_agent", r.UserAgent())
+
+	// the tracer may have changed the sampling decision of a new trace
+	if span.SpanContext().Sampled != sc.Sampled {
+		respHeader := w.Header()
+		requestidmw.SetSpanInResponseHeader(&respHeader, span.SpanContext(), false)
+	}
+
+	rw, ok := w.(negroni.ResponseWriter)
+	if !ok {
+		rw = negroni.NewResponseWriter(w)
+	}
+	next(rw, r.WithContext(trace.ContextWithSpan(r.Context(), span)))
+
+	status := rw.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttribute("http.status_code", status)
+	if status >= 500 {
+		span.SetStatus(trace.StatusError, http.StatusText(status))
+	}
+}
+
+// New creates a new SpanHandler middleware which uses the default tracer.
+func New() SpanHandler {
+	return SpanHandler{}
+}
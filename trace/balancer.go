@@ -0,0 +1,268 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/wrapp/gokit/log"
+)
+
+// BalancePolicy is the policy of a LoadBalancer to pick an endpoint.
+type BalancePolicy int
+
+const (
+	// RoundRobin picks the endpoints in turn.
+	RoundRobin BalancePolicy = iota
+	// LeastInflight picks the endpoint with the fewest requests in flight. A request is in
+	// flight until the body of its response is closed.
+	LeastInflight
+)
+
+func (p BalancePolicy) String() string {
+	if p == LeastInflight {
+		return "least-inflight"
+	}
+	return "round-robin"
+}
+
+// LoadBalancer spreads the requests to logical urls, e.g `svc://orders/orders/42`, across the
+// endpoints of the service. The endpoints are resolved with Resolver and the resolution is used
+// for RefreshInterval, the last successful one is kept if a refresh fails. An endpoint is
+// picked according to Policy. An endpoint is ejected for EjectionTime after
+// ConsecutiveFailures failed requests in a row, a zero threshold disables the ejection. Requests
+// which fail with a network error or a 5xx status are failures, requests which are cancelled by
+// their context are not counted. If all the endpoints are ejected then all of them are used.
+// Ejections are logged with Logger. Requests to other schemes are not changed.
+type LoadBalancer struct {
+	Resolver            Resolver
+	Policy              BalancePolicy
+	RefreshInterval     time.Duration
+	ConsecutiveFailures int
+	EjectionTime        time.Duration
+	Logger              *kitlog.Logger
+
+	mu       sync.Mutex
+	services map[string]*service
+}
+
+// service is the state of the endpoints of a single service.
+type service struct {
+	endpoints []*endpoint
+	byURL     map[string]*endpoint
+	resolved  time.Time
+	next      int
+}
+
+// endpoint is the state of a single endpoint.
+type endpoint struct {
+	url          *url.URL
+	inflight     int
+	consecutive  int
+	ejectedUntil time.Time
+}
+
+// NewLoadBalancer creates a round-robin LoadBalancer with the resolver. It resolves the
+// services every 30s and ejects an endpoint for 30s after 3 consecutive failures. It logs with
+// the default logger.
+func NewLoadBalancer(resolver Resolver) *LoadBalancer {
+	return &LoadBalancer{
+		Resolver:            resolver,
+		Policy:              RoundRobin,
+		RefreshInterval:     30 * time.Second,
+		ConsecutiveFailures: 3,
+		EjectionTime:        30 * time.Second,
+		Logger:              kitlog.Default(),
+	}
+}
+
+// Endpoints returns the resolved endpoints of the service which are not ejected.
+func (b *LoadBalancer) Endpoints(ctx context.Context, name string) ([]*url.URL, error) {
+	s, err := b.service(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var endpoints []*url.URL
+	now := time.Now()
+	for _, e := range s.endpoints {
+		if !now.Before(e.ejectedUntil) {
+			endpoints = append(endpoints, e.url)
+		}
+	}
+	return endpoints, nil
+}
+
+// Do performs the request with the passed function. If the url of the request has the
+// ServiceScheme then it is sent to an endpoint of the service and the outcome is recorded.
+func (b *LoadBalancer) Do(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if req.URL.Scheme != ServiceScheme {
+		return do(req)
+	}
+	name := req.URL.Hostname()
+	s, err := b.service(req.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	e := b.pick(s)
+
+	out := req.Clone(req.Context())
+	out.URL = e.target(req.URL)
+	out.Host = ""
+	resp, err := do(out)
+	if req.Context().Err() == nil {
+		b.record(name, e, err != nil || resp.StatusCode >= 500)
+	}
+	if err != nil {
+		b.done(e)
+		return nil, err
+	}
+	resp.Body = &inflightBody{ReadCloser: resp.Body, done: func() { b.done(e) }}
+	return resp, nil
+}
+
+// service returns the service with fresh endpoints, it resolves them if needed.
+func (b *LoadBalancer) service(ctx context.Context, name string) (*service, error) {
+	b.mu.Lock()
+	s, ok := b.services[name]
+	if ok && time.Since(s.resolved) < b.RefreshInterval {
+		b.mu.Unlock()
+		return s, nil
+	}
+	b.mu.Unlock()
+
+	urls, err := b.Resolver.Resolve(ctx, name)
+	if err == nil && len(urls) == 0 {
+		err = ErrNoEndpoints
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.services == nil {
+		b.services = map[string]*service{}
+	}
+	s, ok = b.services[name]
+	if err != nil {
+		if ok {
+			return s, nil
+		}
+		return nil, &ResolveError{Service: name, Err: err}
+	}
+	if !ok {
+		s = &service{byURL: map[string]*endpoint{}}
+		b.services[name] = s
+	}
+
+	endpoints := make([]*endpoint, 0, len(urls))
+	byURL := make(map[string]*endpoint, len(urls))
+	for _, u := range urls {
+		e, ok := s.byURL[u.String()]
+		if !ok {
+			e = &endpoint{url: u}
+		}
+		endpoints = append(endpoints, e)
+		byURL[u.String()] = e
+	}
+	s.endpoints, s.byURL, s.resolved = endpoints, byURL, time.Now()
+	return s, nil
+}
+
+// pick picks an endpoint of the service and counts the request as in flight.
+func (b *LoadBalancer) pick(s *service) *endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	candidates := make([]*endpoint, 0, len(s.endpoints))
+	for _, e := range s.endpoints {
+		if !now.Before(e.ejectedUntil) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = s.endpoints
+	}
+
+	start := s.next % len(candidates)
+	s.next++
+	picked := candidates[start]
+	if b.Policy == LeastInflight {
+		// Ties are broken in turn so that idle endpoints share the load.
+		for i := 1; i < len(candidates); i++ {
+			if e := candidates[(start+i)%len(candidates)]; e.inflight < picked.inflight {
+				picked = e
+			}
+		}
+	}
+	picked.inflight++
+	return picked
+}
+
+// record records the outcome of a request and ejects the endpoint if it keeps failing.
+func (b *LoadBalancer) record(name string, e *endpoint, failed bool) {
+	b.mu.Lock()
+	if !failed {
+		e.consecutive = 0
+		b.mu.Unlock()
+		return
+	}
+	e.consecutive++
+	now := time.Now()
+	eject := b.ConsecutiveFailures > 0 && e.consecutive >= b.ConsecutiveFailures && !now.Before(e.ejectedUntil)
+	if eject {
+		e.consecutive = 0
+		e.ejectedUntil = now.Add(b.EjectionTime)
+	}
+	b.mu.Unlock()
+
+	if eject {
+		logger := b.Logger
+		if logger == nil {
+			logger = kitlog.Default()
+		}
+		logger.WithFields(map[string]interface{}{
+			"downstream":  name,
+			"endpoint":    e.url.String(),
+			"ejection_ms": b.EjectionTime.Milliseconds(),
+		}).Warn("endpoint ejected")
+	}
+}
+
+func (b *LoadBalancer) done(e *endpoint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.inflight--
+}
+
+// target returns the url of the request at the endpoint.
+func (e *endpoint) target(u *url.URL) *url.URL {
+	t := *e.url
+	base := strings.TrimSuffix(t.Path, "/")
+	t.Path = base + u.Path
+	if u.RawPath != "" {
+		t.RawPath = strings.TrimSuffix(e.url.EscapedPath(), "/") + u.RawPath
+	} else {
+		t.RawPath = ""
+	}
+	t.RawQuery = u.RawQuery
+	t.Fragment = ""
+	return &t
+}
+
+// inflightBody calls done once when the body is closed.
+type inflightBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *inflightBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
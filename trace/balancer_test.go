@@ -0,0 +1,184 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/log/logtest"
+)
+
+func TestLoadBalancer(t *testing.T) {
+	t.Parallel()
+	a, b := endpointServer(t, "a", 200), endpointServer(t, "b", 200)
+	client := newTestClient()
+	client.Retry = nil
+	client.Balancer = NewLoadBalancer(staticResolver(a.URL, b.URL+"/api/"))
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		_, body := get(t, client, "svc://orders/orders/42?expand=items", nil)
+		got = append(got, body)
+	}
+	want := []string{"a /orders/42?expand=items", "b /api/orders/42?expand=items", "a /orders/42?expand=items", "b /api/orders/42?expand=items"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q got %q", want, got)
+	}
+
+	if _, body := get(t, client, a.URL+"/direct", nil); body != "a /direct" {
+		t.Errorf("Expected other schemes to be sent as they are got %q", body)
+	}
+}
+
+func TestLoadBalancerEjection(t *testing.T) {
+	t.Parallel()
+	healthy, failing := endpointServer(t, "healthy", 200), endpointServer(t, "failing", 500)
+	rec := logtest.New(t)
+	lb := NewLoadBalancer(staticResolver(healthy.URL, failing.URL))
+	lb.ConsecutiveFailures = 2
+	lb.Logger = rec.Logger
+	client := newTestClient()
+	client.Retry = nil
+	client.Balancer = lb
+
+	for i := 0; i < 4; i++ {
+		get(t, client, "svc://orders/", nil)
+	}
+	rec.AssertLogged(log.WarnLevel, "endpoint ejected", map[string]interface{}{"downstream": "orders", "endpoint": failing.URL})
+	for i := 0; i < 3; i++ {
+		if _, body := get(t, client, "svc://orders/", nil); body != "healthy /" {
+			t.Errorf("Expected ejected endpoint not to be used got %q", body)
+		}
+	}
+	if endpoints, _ := lb.Endpoints(context.Background(), "orders"); len(endpoints) != 1 || endpoints[0].String() != healthy.URL {
+		t.Errorf("Expected only the healthy endpoint got %v", endpoints)
+	}
+
+	t.Run("AllEjected", func(t *testing.T) {
+		t.Parallel()
+		lb := NewLoadBalancer(staticResolver(failing.URL))
+		lb.ConsecutiveFailures = 1
+		lb.Logger = logtest.New(t).Logger
+		client := newTestClient()
+		client.Retry = nil
+		client.Balancer = lb
+		for i := 0; i < 3; i++ {
+			if _, body := get(t, client, "svc://orders/", nil); body != "failing /" {
+				t.Errorf("Expected ejected endpoints to be used when there are no others got %q", body)
+			}
+		}
+	})
+}
+
+func TestLoadBalancerLeastInflight(t *testing.T) {
+	t.Parallel()
+	lb := NewLoadBalancer(staticResolver("http://a", "http://b"))
+	lb.Policy = LeastInflight
+	do := func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(req.URL.Host))}, nil
+	}
+	send := func() *http.Response {
+		req, _ := http.NewRequest("GET", "svc://orders/", nil)
+		resp, err := lb.Do(req, do)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	host := func(resp *http.Response) string {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	first := send()
+	second := send()
+	if host(first) != "a" || host(second) != "b" {
+		t.Fatalf("Expected idle endpoints to be picked in turn")
+	}
+	second.Body.Close()
+	for i := 0; i < 3; i++ {
+		resp := send()
+		if h := host(resp); h != "b" {
+			t.Errorf("Expected the endpoint without requests in flight got %s", h)
+		}
+		resp.Body.Close()
+	}
+	first.Body.Close()
+}
+
+func TestLoadBalancerResolve(t *testing.T) {
+	t.Parallel()
+	srv := endpointServer(t, "a", 200)
+	calls := 0
+	lb := NewLoadBalancer(ResolverFunc(func(_ context.Context, service string) ([]*url.URL, error) {
+		calls++
+		if service != "orders" {
+			return nil, nil
+		}
+		if calls > 1 {
+			return nil, errors.New("resolver is down")
+		}
+		return ParseEndpoints([]string{srv.URL})
+	}))
+	lb.RefreshInterval = 0
+	client := newTestClient()
+	client.Retry = nil
+	client.Balancer = lb
+
+	for i := 0; i < 2; i++ {
+		if _, body := get(t, client, "svc://orders/", nil); body != "a /" {
+			t.Errorf("Expected last resolution to be kept got %q", body)
+		}
+	}
+
+	_, err := client.GetContext(context.Background(), "svc://unknown/")
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || resolveErr.Service != "unknown" || !errors.Is(err, ErrNoEndpoints) {
+		t.Errorf("Expected ResolveError got %v", err)
+	}
+}
+
+func TestResolvers(t *testing.T) {
+	ctx := context.Background()
+	endpoints := func(urls []*url.URL, err error) string {
+		if err != nil {
+			return "error"
+		}
+		var s []string
+		for _, u := range urls {
+			s = append(s, u.String())
+		}
+		return strings.Join(s, ",")
+	}
+
+	t.Run("Env", func(t *testing.T) {
+		t.Setenv("ORDER_HISTORY_URLS", "http://10.0.0.1:8080, http://10.0.0.2:8080")
+		if got := endpoints(EnvResolver{}.Resolve(ctx, "order-history")); got != "http://10.0.0.1:8080,http://10.0.0.2:8080" {
+			t.Errorf("Unexpected endpoints %s", got)
+		}
+		t.Setenv("ORDERS_URLS", "10.0.0.1:8080")
+		if got := endpoints(EnvResolver{}.Resolve(ctx, "orders")); got != "error" {
+			t.Errorf("Expected relative url to fail got %s", got)
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "services.json")
+		ioutil.WriteFile(path, []byte(`{"orders": ["http://10.0.0.1:8080/api"]}`), 0644)
+		r := FileResolver{Path: path}
+		if got := endpoints(r.Resolve(ctx, "orders")); got != "http://10.0.0.1:8080/api" {
+			t.Errorf("Unexpected endpoints %s", got)
+		}
+		if got := endpoints(r.Resolve(ctx, "users")); got != "" {
+			t.Errorf("Expected no endpoints got %s", got)
+		}
+	})
+}
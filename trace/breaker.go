@@ -0,0 +1,288 @@
+package trace
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	kitlog "github.com/wrapp/gokit/log"
+	"github.com/wrapp/gokit/metrics"
+)
+
+// BreakerState is the state of the circuit of a host.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all the requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all the requests with a CircuitOpenError.
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of trial requests through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenError is returned for requests which are rejected because the circuit of their
+// host is open.
+type CircuitOpenError struct {
+	Host string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open for %s", e.Host)
+}
+
+// CircuitBreaker stops sending requests to a host which keeps failing. Each host has its own
+// circuit. The circuit opens after ConsecutiveFailures failed requests in a row, or when the
+// ratio of failed requests within Window reaches FailureRatio after at least MinRequests
+// requests. Zero thresholds are disabled. While the circuit is open the requests fail fast with
+// a CircuitOpenError. After OpenTimeout the circuit becomes half-open and lets HalfOpenRequests
+// trial requests through. The circuit closes if all of them succeed and opens again otherwise.
+// Requests which fail with a network error or a 5xx status are failures, requests which are
+// cancelled by their context are not counted. State changes are logged with Logger and exposed
+// in Metrics.
+type CircuitBreaker struct {
+	FailureRatio        float64
+	MinRequests         int
+	ConsecutiveFailures int
+	Window              time.Duration
+	OpenTimeout         time.Duration
+	HalfOpenRequests    int
+	Logger              *kitlog.Logger
+	Metrics             *metrics.Registry
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// circuit is the state of a single host.
+type circuit struct {
+	state       BreakerState
+	generation  uint64
+	changed     time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	inFlight    int
+	successes   int
+}
+
+// breakerMetrics are the metrics of the circuit breakers of a Registry.
+type breakerMetrics struct {
+	state       *metrics.Gauge
+	transitions *metrics.Counter
+	rejected    *metrics.Counter
+}
+
+// NewCircuitBreaker creates a CircuitBreaker which opens after 5 consecutive failures, or when
+// half of at least 20 requests within 10s failed. It stays open for 10s and closes after a
+// successful trial request. It logs with the default logger and exposes its metrics in the
+// default registry.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureRatio:        0.5,
+		MinRequests:         20,
+		ConsecutiveFailures: 5,
+		Window:              10 * time.Second,
+		OpenTimeout:         10 * time.Second,
+		HalfOpenRequests:    1,
+		Logger:              kitlog.Default(),
+		Metrics:             metrics.Default(),
+	}
+}
+
+// State returns the state of the circuit of the host.
+func (b *CircuitBreaker) State(host string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.hosts[host]
+	if !ok {
+		return BreakerClosed
+	}
+	if c.state == BreakerOpen && time.Since(c.changed) >= b.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+// Do performs the request with the passed function if the circuit of the host of the request
+// allows it, and records the outcome.
+func (b *CircuitBreaker) Do(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	host := req.URL.Host
+	generation, err := b.allow(host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(req)
+	if req.Context().Err() != nil {
+		b.release(host, generation)
+	} else {
+		b.record(host, generation, err != nil || resp.StatusCode >= 500)
+	}
+	return resp, err
+}
+
+func (b *CircuitBreaker) allow(host string) (uint64, error) {
+	b.mu.Lock()
+	c := b.circuit(host)
+	now := time.Now()
+	from := c.state
+	var err error
+
+	switch c.state {
+	case BreakerOpen:
+		if now.Sub(c.changed) < b.OpenTimeout {
+			err = &CircuitOpenError{Host: host}
+			break
+		}
+		b.setState(c, BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		if c.inFlight >= b.halfOpenRequests() {
+			err = &CircuitOpenError{Host: host}
+			break
+		}
+		c.inFlight++
+	default:
+		if b.Window > 0 && now.Sub(c.windowStart) >= b.Window {
+			c.windowStart, c.requests, c.failures = now, 0, 0
+		}
+	}
+	to, generation := c.state, c.generation
+	b.mu.Unlock()
+
+	b.changed(host, from, to)
+	if err != nil {
+		b.metrics().rejected.Inc(host)
+	}
+	return generation, err
+}
+
+// record records the outcome of a request which was allowed in the generation.
+func (b *CircuitBreaker) record(host string, generation uint64, failed bool) {
+	b.mu.Lock()
+	c := b.circuit(host)
+	from := c.state
+	if c.generation != generation {
+		b.mu.Unlock()
+		return
+	}
+	now := time.Now()
+
+	switch c.state {
+	case BreakerHalfOpen:
+		c.inFlight--
+		if failed {
+			b.setState(c, BreakerOpen, now)
+			break
+		}
+		c.successes++
+		if c.successes >= b.halfOpenRequests() {
+			b.setState(c, BreakerClosed, now)
+		}
+	case BreakerClosed:
+		c.requests++
+		c.consecutive++
+		if failed {
+			c.failures++
+		} else {
+			c.consecutive = 0
+		}
+		if b.ConsecutiveFailures > 0 && c.consecutive >= b.ConsecutiveFailures ||
+			b.FailureRatio > 0 && c.requests >= b.MinRequests &&
+				float64(c.failures)/float64(c.requests) >= b.FailureRatio {
+			b.setState(c, BreakerOpen, now)
+		}
+	}
+	to := c.state
+	b.mu.Unlock()
+
+	b.changed(host, from, to)
+}
+
+// release frees the slot of a trial request whose outcome is not counted.
+func (b *CircuitBreaker) release(host string, generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.circuit(host); c.generation == generation && c.state == BreakerHalfOpen {
+		c.inFlight--
+	}
+}
+
+func (b *CircuitBreaker) circuit(host string) *circuit {
+	if b.hosts == nil {
+		b.hosts = map[string]*circuit{}
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.hosts[host] = c
+	}
+	return c
+}
+
+// setState moves the circuit to the state and resets its counters. Outcomes of requests which
+// were allowed in the previous state are ignored.
+func (b *CircuitBreaker) setState(c *circuit, state BreakerState, now time.Time) {
+	*c = circuit{
+		state:       state,
+		generation:  c.generation + 1,
+		changed:     now,
+		windowStart: now,
+	}
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return b.HalfOpenRequests
+}
+
+// changed logs the state change and updates the metrics, if the state changed.
+func (b *CircuitBreaker) changed(host string, from, to BreakerState) {
+	if from == to {
+		return
+	}
+	m := b.metrics()
+	m.state.Set(float64(to), host)
+	m.transitions.Inc(host, to.String())
+
+	logger := b.Logger
+	if logger == nil {
+		logger = kitlog.Default()
+	}
+	entry := logger.WithFields(map[string]interface{}{"host": host, "from": from.String(), "to": to.String()})
+	if to == BreakerOpen {
+		entry.Warn("circuit breaker opened")
+	} else {
+		entry.Info("circuit breaker state changed")
+	}
+}
+
+func (b *CircuitBreaker) metrics() breakerMetrics {
+	r := b.Metrics
+	if r == nil {
+		r = metrics.Default()
+	}
+	return breakerMetrics{
+		state: r.Gauge("http_client_circuit_state",
+			"State of the circuit breaker of a host, 0 closed, 1 open, 2 half-open.", "host"),
+		transitions: r.Counter("http_client_circuit_transitions_total",
+			"Number of state changes of the circuit breaker of a host.", "host", "state"),
+		rejected: r.Counter("http_client_circuit_rejected_total",
+			"Number of requests rejected by an open circuit breaker.", "host"),
+	}
+}
@@ -0,0 +1,151 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/log/logtest"
+	"github.com/wrapp/gokit/metrics"
+)
+
+func newTestBreaker(t *testing.T) (*CircuitBreaker, *logtest.Recorder) {
+	rec := logtest.New(t)
+	b := NewCircuitBreaker()
+	b.ConsecutiveFailures = 3
+	b.OpenTimeout = 20 * time.Millisecond
+	b.Logger = rec.Logger
+	b.Metrics = metrics.NewRegistry()
+	return b, rec
+}
+
+// respond returns a func for CircuitBreaker.Do which responds with the status.
+func respond(status int) func(*http.Request) (*http.Response, error) {
+	return func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Body: http.NoBody}, nil
+	}
+}
+
+func breakerRequest(host string) *http.Request {
+	req, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+	return req
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+	b, rec := newTestBreaker(t)
+
+	for i := 0; i < 3; i++ {
+		b.Do(breakerRequest("a"), respond(http.StatusInternalServerError))
+	}
+	if s := b.State("a"); s != BreakerOpen {
+		t.Fatalf("Expected circuit to be open got %s", s)
+	}
+	if s := b.State("b"); s != BreakerClosed {
+		t.Errorf("Expected circuit of another host to be closed got %s", s)
+	}
+	rec.AssertLogged(log.WarnLevel, "circuit breaker opened", map[string]interface{}{"host": "a", "to": "open"})
+
+	called := false
+	_, err := b.Do(breakerRequest("a"), func(*http.Request) (*http.Response, error) {
+		called = true
+		return nil, nil
+	})
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || open.Host != "a" || called {
+		t.Errorf("Expected the request to fail fast with CircuitOpenError got %v", err)
+	}
+	if v := b.metrics().rejected.Value("a"); v != 1 {
+		t.Errorf("Expected 1 rejected request got %v", v)
+	}
+
+	// a failed trial request opens the circuit again
+	time.Sleep(b.OpenTimeout)
+	if s := b.State("a"); s != BreakerHalfOpen {
+		t.Fatalf("Expected circuit to be half-open got %s", s)
+	}
+	b.Do(breakerRequest("a"), respond(http.StatusBadGateway))
+	if s := b.State("a"); s != BreakerOpen {
+		t.Fatalf("Expected circuit to open again got %s", s)
+	}
+
+	// a successful trial request closes the circuit
+	time.Sleep(b.OpenTimeout)
+	b.Do(breakerRequest("a"), respond(http.StatusOK))
+	if s := b.State("a"); s != BreakerClosed {
+		t.Fatalf("Expected circuit to be closed got %s", s)
+	}
+	if v := b.metrics().state.Value("a"); v != float64(BreakerClosed) {
+		t.Errorf("Expected state metric to be closed got %v", v)
+	}
+	if v := b.metrics().transitions.Value("a", "open"); v != 2 {
+		t.Errorf("Expected 2 transitions to open got %v", v)
+	}
+}
+
+func TestCircuitBreakerFailureRatio(t *testing.T) {
+	t.Parallel()
+	b, _ := newTestBreaker(t)
+	b.ConsecutiveFailures = 0
+	b.MinRequests = 10
+
+	for i := 0; i < 9; i++ {
+		status := http.StatusOK
+		if i%2 == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		b.Do(breakerRequest("a"), respond(status))
+	}
+	if s := b.State("a"); s != BreakerClosed {
+		t.Fatalf("Expected circuit to stay closed before MinRequests got %s", s)
+	}
+	b.Do(breakerRequest("a"), respond(http.StatusOK))
+	if s := b.State("a"); s != BreakerOpen {
+		t.Fatalf("Expected circuit to open at the failure ratio got %s", s)
+	}
+}
+
+func TestCircuitBreakerIgnoresCancelled(t *testing.T) {
+	t.Parallel()
+	b, _ := newTestBreaker(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 5; i++ {
+		b.Do(breakerRequest("a").WithContext(ctx), func(*http.Request) (*http.Response, error) {
+			return nil, context.Canceled
+		})
+	}
+	if s := b.State("a"); s != BreakerClosed {
+		t.Errorf("Expected cancelled requests not to open the circuit got %s", s)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := newTestClient()
+	client.Breaker, _ = newTestBreaker(t)
+	client.Breaker.OpenTimeout = time.Minute
+
+	// the retries stop when the circuit opens
+	_, err := client.GetContext(context.Background(), srv.URL)
+	var open *CircuitOpenError
+	if !errors.As(err, &open) {
+		t.Fatalf("Expected CircuitOpenError got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 requests to reach the server got %d", calls)
+	}
+}
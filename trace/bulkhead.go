@@ -0,0 +1,223 @@
+package trace
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wrapp/gokit/env"
+	"github.com/wrapp/gokit/metrics"
+)
+
+// BulkheadFullError is returned for requests which are rejected because the bulkhead of their
+// host is full, or because they waited in its queue for longer than the queue timeout. It has
+// the status 503 so that e.g errormw responds with Service Unavailable.
+type BulkheadFullError struct {
+	Host    string
+	Timeout bool
+}
+
+func (e *BulkheadFullError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("timed out in the bulkhead queue for %s", e.Host)
+	}
+	return fmt.Sprintf("bulkhead is full for %s", e.Host)
+}
+
+// Status returns 503, see errormw.StatusError.
+func (e *BulkheadFullError) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+// BulkheadLimit is the limit of the concurrent requests to a host. Up to MaxConcurrent
+// requests are in flight, a request is in flight until the body of its response is closed.
+// Up to MaxQueue more requests wait for at most QueueTimeout. A zero MaxConcurrent disables
+// the limit.
+type BulkheadLimit struct {
+	MaxConcurrent int
+	MaxQueue      int
+	QueueTimeout  time.Duration
+}
+
+// Bulkhead limits the concurrent requests to each host so that a slow host does not consume
+// all the outbound capacity of the service. The requests to the logical urls of a LoadBalancer
+// are limited for each endpoint of the service. The limit of a host is read from Hosts, from the
+// environment variables of the host, see NewBulkhead, or is Limit. The limit of a host is fixed
+// by its first request. Requests which are rejected fail fast with a BulkheadFullError. The
+// requests in flight, the queued requests, the utilisation and the rejections of each host are
+// exposed in Metrics, or the default registry if it is nil.
+type Bulkhead struct {
+	Limit   BulkheadLimit
+	Hosts   map[string]BulkheadLimit
+	Metrics *metrics.Registry
+
+	mu           sync.Mutex
+	compartments map[string]*compartment
+}
+
+// compartment is the state of a single host.
+type compartment struct {
+	limit  BulkheadLimit
+	slots  chan struct{}
+	queued int
+}
+
+// bulkheadMetrics are the metrics of the bulkheads of a Registry.
+type bulkheadMetrics struct {
+	inFlight    *metrics.Gauge
+	queued      *metrics.Gauge
+	utilisation *metrics.Gauge
+	rejected    *metrics.Counter
+}
+
+// NewBulkhead creates a Bulkhead which allows 100 concurrent requests to each host, and queues
+// up to 50 more for at most 500ms. The defaults are read from the environment variables
+// `BULKHEAD_MAX_CONCURRENT`, `BULKHEAD_MAX_QUEUE` and `BULKHEAD_QUEUE_TIMEOUT_MS`. The limit of
+// a single host is read from the same variables with the upper case host, with other
+// characters than letters and digits replaced by `_`, after `BULKHEAD_` e.g
+// `BULKHEAD_ORDERS_INTERNAL_8080_MAX_CONCURRENT` for `orders.internal:8080` or
+// `BULKHEAD_API_EXAMPLE_COM_MAX_QUEUE` for `api.example.com`.
+func NewBulkhead() *Bulkhead {
+	return &Bulkhead{
+		Limit: BulkheadLimit{
+			MaxConcurrent: env.DefaultInt("BULKHEAD_MAX_CONCURRENT", 100),
+			MaxQueue:      env.DefaultInt("BULKHEAD_MAX_QUEUE", 50),
+			QueueTimeout:  time.Duration(env.DefaultInt("BULKHEAD_QUEUE_TIMEOUT_MS", 500)) * time.Millisecond,
+		},
+		Metrics: metrics.Default(),
+	}
+}
+
+// LimitFor returns the limit of the host.
+func (b *Bulkhead) LimitFor(host string) BulkheadLimit {
+	if limit, ok := b.Hosts[host]; ok {
+		return limit
+	}
+	prefix := "BULKHEAD_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, host) + "_"
+	return BulkheadLimit{
+		MaxConcurrent: env.DefaultInt(prefix+"MAX_CONCURRENT", b.Limit.MaxConcurrent),
+		MaxQueue:      env.DefaultInt(prefix+"MAX_QUEUE", b.Limit.MaxQueue),
+		QueueTimeout:  time.Duration(env.DefaultInt(prefix+"QUEUE_TIMEOUT_MS", int(b.Limit.QueueTimeout/time.Millisecond))) * time.Millisecond,
+	}
+}
+
+// InFlight returns the number of requests in flight to the host.
+func (b *Bulkhead) InFlight(host string) int {
+	return len(b.compartment(host).slots)
+}
+
+// Do performs the request with the passed function when the bulkhead of its host has room.
+func (b *Bulkhead) Do(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	host := req.URL.Host
+	c := b.compartment(host)
+	if c.slots == nil {
+		return do(req)
+	}
+	if err := b.acquire(req, host, c); err != nil {
+		return nil, err
+	}
+	resp, err := do(req)
+	if err != nil {
+		b.release(host, c)
+		return nil, err
+	}
+	resp.Body = &inflightBody{ReadCloser: resp.Body, done: func() { b.release(host, c) }}
+	return resp, nil
+}
+
+func (b *Bulkhead) acquire(req *http.Request, host string, c *compartment) error {
+	m := b.metrics()
+	select {
+	case c.slots <- struct{}{}:
+		b.update(m, host, c)
+		return nil
+	default:
+	}
+
+	b.mu.Lock()
+	if c.queued >= c.limit.MaxQueue {
+		b.mu.Unlock()
+		m.rejected.Inc(host, "full")
+		return &BulkheadFullError{Host: host}
+	}
+	c.queued++
+	b.mu.Unlock()
+	b.update(m, host, c)
+	defer func() {
+		b.mu.Lock()
+		c.queued--
+		b.mu.Unlock()
+		b.update(m, host, c)
+	}()
+
+	timer := time.NewTimer(c.limit.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		m.rejected.Inc(host, "timeout")
+		return &BulkheadFullError{Host: host, Timeout: true}
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func (b *Bulkhead) release(host string, c *compartment) {
+	<-c.slots
+	b.update(b.metrics(), host, c)
+}
+
+func (b *Bulkhead) compartment(host string) *compartment {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.compartments == nil {
+		b.compartments = map[string]*compartment{}
+	}
+	c, ok := b.compartments[host]
+	if !ok {
+		c = &compartment{limit: b.LimitFor(host)}
+		if c.limit.MaxConcurrent > 0 {
+			c.slots = make(chan struct{}, c.limit.MaxConcurrent)
+		}
+		b.compartments[host] = c
+	}
+	return c
+}
+
+// update sets the gauges of the host.
+func (b *Bulkhead) update(m bulkheadMetrics, host string, c *compartment) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inFlight, queued := len(c.slots), c.queued
+	m.inFlight.Set(float64(inFlight), host)
+	m.queued.Set(float64(queued), host)
+	m.utilisation.Set(float64(inFlight)/float64(cap(c.slots)), host)
+}
+
+func (b *Bulkhead) metrics() bulkheadMetrics {
+	r := b.Metrics
+	if r == nil {
+		r = metrics.Default()
+	}
+	return bulkheadMetrics{
+		inFlight: r.Gauge("http_client_bulkhead_in_flight",
+			"Number of requests in flight to a host.", "host"),
+		queued: r.Gauge("http_client_bulkhead_queued",
+			"Number of requests waiting in the bulkhead queue of a host.", "host"),
+		utilisation: r.Gauge("http_client_bulkhead_utilisation",
+			"Ratio of the concurrency limit of a host which is in use.", "host"),
+		rejected: r.Counter("http_client_bulkhead_rejected_total",
+			"Number of requests rejected by the bulkhead of a host by reason, full or timeout.", "host", "reason"),
+	}
+}
@@ -0,0 +1,170 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wrapp/gokit/metrics"
+)
+
+func newTestBulkhead(limit BulkheadLimit) *Bulkhead {
+	return &Bulkhead{Limit: limit, Metrics: metrics.NewRegistry()}
+}
+
+func bulkheadDo(b *Bulkhead, ctx context.Context) (*http.Response, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://orders/", nil)
+	return b.Do(req, func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+}
+
+func TestBulkhead(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("Full", func(t *testing.T) {
+		t.Parallel()
+		b := newTestBulkhead(BulkheadLimit{MaxConcurrent: 2})
+		first, _ := bulkheadDo(b, ctx)
+		bulkheadDo(b, ctx)
+
+		_, err := bulkheadDo(b, ctx)
+		var full *BulkheadFullError
+		if !errors.As(err, &full) || full.Host != "orders" || full.Timeout || full.Status() != http.StatusServiceUnavailable {
+			t.Fatalf("Expected BulkheadFullError got %v", err)
+		}
+
+		m := b.metrics()
+		if v := m.utilisation.Value("orders"); v != 1 {
+			t.Errorf("Expected utilisation 1 got %v", v)
+		}
+		if v := m.rejected.Value("orders", "full"); v != 1 {
+			t.Errorf("Expected 1 rejection got %v", v)
+		}
+
+		first.Body.Close()
+		if b.InFlight("orders") != 1 || m.inFlight.Value("orders") != 1 {
+			t.Errorf("Expected closed response to free its slot got %d", b.InFlight("orders"))
+		}
+		if _, err := bulkheadDo(b, ctx); err != nil {
+			t.Errorf("Expected request to be let through got %v", err)
+		}
+	})
+
+	t.Run("QueueTimeout", func(t *testing.T) {
+		t.Parallel()
+		b := newTestBulkhead(BulkheadLimit{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 10 * time.Millisecond})
+		bulkheadDo(b, ctx)
+
+		_, err := bulkheadDo(b, ctx)
+		var full *BulkheadFullError
+		if !errors.As(err, &full) || !full.Timeout {
+			t.Errorf("Expected queue timeout got %v", err)
+		}
+		if v := b.metrics().rejected.Value("orders", "timeout"); v != 1 {
+			t.Errorf("Expected 1 rejection got %v", v)
+		}
+	})
+
+	t.Run("Queued", func(t *testing.T) {
+		t.Parallel()
+		b := newTestBulkhead(BulkheadLimit{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: time.Minute})
+		first, _ := bulkheadDo(b, ctx)
+
+		done := make(chan error)
+		go func() {
+			_, err := bulkheadDo(b, ctx)
+			done <- err
+		}()
+		for b.metrics().queued.Value("orders") != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := bulkheadDo(b, ctx); err == nil {
+			t.Errorf("Expected full queue to reject the request")
+		}
+		first.Body.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Expected queued request to be let through got %v", err)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		t.Parallel()
+		b := newTestBulkhead(BulkheadLimit{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: time.Minute})
+		bulkheadDo(b, ctx)
+
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, err := bulkheadDo(b, ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context error got %v", err)
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		t.Parallel()
+		b := newTestBulkhead(BulkheadLimit{})
+		for i := 0; i < 10; i++ {
+			if _, err := bulkheadDo(b, ctx); err != nil {
+				t.Fatalf("Expected no limit got %v", err)
+			}
+		}
+	})
+}
+
+func TestBulkheadClient(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	client := newTestClient()
+	client.Bulkhead = newTestBulkhead(BulkheadLimit{MaxConcurrent: 1})
+	client.Balancer = NewLoadBalancer(staticResolver(srv.URL))
+	go client.GetContext(context.Background(), "svc://orders/")
+	for atomic.LoadInt32(&calls) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := client.GetContext(context.Background(), "svc://orders/")
+	var full *BulkheadFullError
+	if !errors.As(err, &full) || full.Host != strings.TrimPrefix(srv.URL, "http://") {
+		t.Errorf("Expected BulkheadFullError of the endpoint got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected rejected request not to be retried got %d calls", n)
+	}
+}
+
+func TestBulkheadLimitFor(t *testing.T) {
+	t.Setenv("BULKHEAD_MAX_CONCURRENT", "10")
+	t.Setenv("BULKHEAD_API_EXAMPLE_COM_MAX_CONCURRENT", "3")
+	t.Setenv("BULKHEAD_API_EXAMPLE_COM_QUEUE_TIMEOUT_MS", "50")
+
+	b := NewBulkhead()
+	b.Hosts = map[string]BulkheadLimit{"orders": {MaxConcurrent: 5}}
+	tests := []struct {
+		host string
+		want BulkheadLimit
+	}{
+		{"users", BulkheadLimit{10, 50, 500 * time.Millisecond}},
+		{"api.example.com", BulkheadLimit{3, 50, 50 * time.Millisecond}},
+		{"orders", BulkheadLimit{MaxConcurrent: 5}},
+	}
+	for _, tt := range tests {
+		if got := b.LimitFor(tt.host); got != tt.want {
+			t.Errorf("Expected %+v for %s got %+v", tt.want, tt.host, got)
+		}
+	}
+}
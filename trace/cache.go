@@ -0,0 +1,509 @@
+package trace
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wrapp/gokit/metrics"
+)
+
+// CacheHeader is the header which a CacheTransport sets in the responses of GET requests. Its
+// value is CacheHit, CacheMiss or CacheRevalidated.
+const CacheHeader = "X-Cache"
+
+const (
+	// CacheHit is a response served from the cache without a request.
+	CacheHit = "HIT"
+	// CacheMiss is a response from the downstream service.
+	CacheMiss = "MISS"
+	// CacheRevalidated is a response served from the cache after the downstream service
+	// responded 304 Not Modified to a conditional request.
+	CacheRevalidated = "REVALIDATED"
+)
+
+const (
+	// DefaultCacheSize is the size of the store created by NewCache.
+	DefaultCacheSize int64 = 32 << 20
+	// DefaultMaxCacheEntrySize is the size of the largest response body which is stored, if
+	// MaxEntrySize of the CacheTransport is not set.
+	DefaultMaxCacheEntrySize int64 = 1 << 20
+	// maxDeltaSeconds caps the values of Cache-Control directives, see RFC 9111 section 1.2.2.
+	maxDeltaSeconds = 1 << 31
+	// maxHeuristicLifetime caps the freshness lifetime derived from Last-Modified.
+	maxHeuristicLifetime = 24 * time.Hour
+)
+
+// heuristicStatus are the status codes which can be cached without explicit freshness, see
+// RFC 9111 section 4.2.2.
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// CachedResponse is a response in a CacheStore. It must not be changed once it is stored.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Vary contains the request headers which are selected by the Vary header of the response.
+	Vary         http.Header
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+// Size returns the approximate number of bytes used by the response.
+func (c *CachedResponse) Size() int64 {
+	n := int64(len(c.Body))
+	for _, h := range []http.Header{c.Header, c.Vary} {
+		for k, vs := range h {
+			for _, v := range vs {
+				n += int64(len(k) + len(v))
+			}
+		}
+	}
+	return n
+}
+
+// CacheStore stores the responses of a CacheTransport by key. It must be safe for concurrent
+// use. A store can be shared between clients, e.g a store backed by Redis between instances.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+	Delete(key string)
+}
+
+// MemoryStore is an in-memory CacheStore which evicts the least recently used responses when
+// the total size of its responses exceeds MaxSize.
+type MemoryStore struct {
+	MaxSize int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key  string
+	resp *CachedResponse
+}
+
+// NewMemoryStore creates a MemoryStore which holds up to maxSize bytes.
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	return &MemoryStore{MaxSize: maxSize, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// NewCache creates a MemoryStore of DefaultCacheSize, e.g for TraceClient.Cache.
+func NewCache() *MemoryStore {
+	return NewMemoryStore(DefaultCacheSize)
+}
+
+// Get returns the response stored with the key and marks it as recently used.
+func (s *MemoryStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(e)
+	return e.Value.(*memoryItem).resp, true
+}
+
+// Set stores the response with the key. Responses larger than MaxSize are not stored.
+func (s *MemoryStore) Set(key string, resp *CachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	size := resp.Size() + int64(len(key))
+	if size > s.MaxSize {
+		return
+	}
+	s.items[key] = s.order.PushFront(&memoryItem{key: key, resp: resp})
+	s.size += size
+	for s.size > s.MaxSize {
+		s.remove(s.order.Back().Value.(*memoryItem).key)
+	}
+}
+
+// Delete removes the response stored with the key.
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+// Len returns the number of stored responses.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+func (s *MemoryStore) remove(key string) {
+	e, ok := s.items[key]
+	if !ok {
+		return
+	}
+	item := e.Value.(*memoryItem)
+	s.size -= item.resp.Size() + int64(len(key))
+	s.order.Remove(e)
+	delete(s.items, key)
+}
+
+// CacheTransport is a private HTTP cache, see RFC 9111. It stores the responses of GET requests
+// in Store according to their `Cache-Control`, `Expires` and `Vary` headers and serves them while
+// they are fresh. Stale responses are revalidated with `If-None-Match` and `If-Modified-Since`
+// when they have an `ETag` or a `Last-Modified` header. The `Cache-Control` directives of the
+// requests are honoured as well, e.g `no-cache` forces a revalidation. Successful requests with
+// other methods invalidate the response stored for their URL. CacheHeader is set in the
+// responses of GET requests and the result is counted per host in Metrics, or the default
+// registry if it is nil. Requests with a `Range` or conditional header bypass the cache.
+type CacheTransport struct {
+	Next         http.RoundTripper
+	Store        CacheStore
+	MaxEntrySize int64
+	Metrics      *metrics.Registry
+}
+
+// RoundTrip serves the request from the cache or performs it with the next transport.
+func (t *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+	if req.Method != "GET" {
+		resp, err := next(t.Next).RoundTrip(req)
+		if err == nil && !isSafe(req.Method) && resp.StatusCode < 400 {
+			t.Store.Delete(key)
+		}
+		return resp, err
+	}
+	if req.Header.Get("Range") != "" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return next(t.Next).RoundTrip(req)
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	cached, ok := t.Store.Get(key)
+	if ok && !cached.matches(req) {
+		cached, ok = nil, false
+	}
+	if ok && cached.fresh(reqCC, time.Now()) {
+		return t.respond(req, cached, CacheHit), nil
+	}
+	if _, only := reqCC["only-if-cached"]; only {
+		t.count(req, CacheMiss)
+		return &http.Response{
+			Status:     "504 Gateway Timeout",
+			StatusCode: http.StatusGatewayTimeout,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{CacheHeader: {CacheMiss}},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	out := req
+	if ok && cached.hasValidators() {
+		out = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			out.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			out.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	requestTime := time.Now()
+	resp, err := next(t.Next).RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	if out != req && resp.StatusCode == http.StatusNotModified {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		cached = cached.freshen(resp.Header, requestTime, responseTime)
+		t.Store.Set(key, cached)
+		return t.respond(req, cached, CacheRevalidated), nil
+	}
+
+	resp.Header.Set(CacheHeader, CacheMiss)
+	t.count(req, CacheMiss)
+	if !storable(reqCC, resp) {
+		if ok && resp.StatusCode < 500 {
+			t.Store.Delete(key)
+		}
+		return resp, nil
+	}
+
+	maxSize := t.MaxEntrySize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxCacheEntrySize
+	}
+	if resp.ContentLength > maxSize {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	header.Del(CacheHeader)
+	t.Store.Set(key, &CachedResponse{
+		StatusCode:   resp.StatusCode,
+		Header:       header,
+		Body:         body,
+		Vary:         varyHeader(req, resp.Header),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	})
+	return resp, nil
+}
+
+// respond returns a response for the request from the cached response.
+func (t *CacheTransport) respond(req *http.Request, c *CachedResponse, status string) *http.Response {
+	t.count(req, status)
+	header := c.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(c.age(time.Now())/time.Second)))
+	header.Set(CacheHeader, status)
+	return &http.Response{
+		Status:        strconv.Itoa(c.StatusCode) + " " + http.StatusText(c.StatusCode),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+func (t *CacheTransport) count(req *http.Request, status string) {
+	r := t.Metrics
+	if r == nil {
+		r = metrics.Default()
+	}
+	r.Counter("http_client_cache_requests_total",
+		"Number of GET requests through the client cache by result.", "host", "result").
+		Inc(req.URL.Host, strings.ToLower(status))
+}
+
+// matches returns whether the request has the same values of the headers selected by Vary.
+func (c *CachedResponse) matches(req *http.Request) bool {
+	for k := range c.Vary {
+		if strings.Join(req.Header.Values(k), ", ") != c.Vary.Get(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh returns whether the response can be served without a revalidation.
+func (c *CachedResponse) fresh(reqCC map[string]string, now time.Time) bool {
+	respCC := parseCacheControl(c.Header)
+	if _, ok := respCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+
+	lifetime, age := c.lifetime(respCC), c.age(now)
+	if v, ok := reqCC["max-age"]; ok {
+		if maxAge, ok := seconds(v); ok && maxAge < lifetime {
+			lifetime = maxAge
+		}
+	}
+	if v, ok := reqCC["min-fresh"]; ok {
+		if minFresh, ok := seconds(v); ok {
+			age += minFresh
+		}
+	}
+	if v, ok := reqCC["max-stale"]; ok {
+		if _, mustRevalidate := respCC["must-revalidate"]; !mustRevalidate {
+			if v == "" {
+				return true
+			}
+			if maxStale, ok := seconds(v); ok {
+				lifetime += maxStale
+			}
+		}
+	}
+	return lifetime > age
+}
+
+// lifetime returns the freshness lifetime of the response from its max-age directive, its
+// Expires header or, as a heuristic, 10% of the time since its Last-Modified header.
+func (c *CachedResponse) lifetime(respCC map[string]string) time.Duration {
+	if v, ok := respCC["max-age"]; ok {
+		maxAge, _ := seconds(v)
+		return maxAge
+	}
+	date := c.date()
+	if v := c.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if !heuristicStatus[c.StatusCode] {
+		return 0
+	}
+	if lastModified, err := http.ParseTime(c.Header.Get("Last-Modified")); err == nil && lastModified.Before(date) {
+		lifetime := date.Sub(lastModified) / 10
+		if lifetime > maxHeuristicLifetime {
+			lifetime = maxHeuristicLifetime
+		}
+		return lifetime
+	}
+	return 0
+}
+
+// age returns the current age of the response, see RFC 9111 section 4.2.3.
+func (c *CachedResponse) age(now time.Time) time.Duration {
+	apparent := c.ResponseTime.Sub(c.date())
+	if apparent < 0 {
+		apparent = 0
+	}
+	ageValue, _ := seconds(c.Header.Get("Age"))
+	corrected := ageValue + c.ResponseTime.Sub(c.RequestTime)
+	if corrected > apparent {
+		apparent = corrected
+	}
+	return apparent + now.Sub(c.ResponseTime)
+}
+
+func (c *CachedResponse) date() time.Time {
+	if date, err := http.ParseTime(c.Header.Get("Date")); err == nil {
+		return date
+	}
+	return c.ResponseTime
+}
+
+func (c *CachedResponse) hasValidators() bool {
+	return c.Header.Get("ETag") != "" || c.Header.Get("Last-Modified") != ""
+}
+
+// freshen returns a copy of the response with the headers of a 304 response, see RFC 9111
+// section 4.3.4.
+func (c *CachedResponse) freshen(header http.Header, requestTime, responseTime time.Time) *CachedResponse {
+	updated := *c
+	updated.Header = c.Header.Clone()
+	for k, vs := range header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		updated.Header[k] = vs
+	}
+	if _, ok := header["Age"]; !ok {
+		updated.Header.Del("Age")
+	}
+	updated.RequestTime, updated.ResponseTime = requestTime, responseTime
+	return &updated
+}
+
+// storable returns whether the response can be stored, see RFC 9111 section 3. It must be
+// reusable: fresh for some time, cacheable by heuristic or revalidatable.
+func storable(reqCC map[string]string, resp *http.Response) bool {
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+	respCC := parseCacheControl(resp.Header)
+	if _, ok := respCC["no-store"]; ok {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	_, maxAge := respCC["max-age"]
+	explicit := maxAge || resp.Header.Get("Expires") != ""
+	if explicit {
+		return heuristicStatus[resp.StatusCode] || resp.StatusCode == 302 || resp.StatusCode == 307
+	}
+	return heuristicStatus[resp.StatusCode] && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "")
+}
+
+// varyHeader returns the request headers selected by the Vary header of the response.
+func varyHeader(req *http.Request, respHeader http.Header) http.Header {
+	var vary http.Header
+	for _, v := range respHeader.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if vary == nil {
+				vary = http.Header{}
+			}
+			vary.Set(name, strings.Join(req.Header.Values(name), ", "))
+		}
+	}
+	return vary
+}
+
+// parseCacheControl returns the directives of the Cache-Control header with lower case names.
+// A `Pragma: no-cache` is treated as `Cache-Control: no-cache` if there is no
+// Cache-Control header.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	values := h.Values("Cache-Control")
+	if len(values) == 0 && strings.EqualFold(h.Get("Pragma"), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	for _, v := range values {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+func seconds(v string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) || n < 0 {
+		return 0, false
+	}
+	if n > maxDeltaSeconds {
+		n = maxDeltaSeconds
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func isSafe(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
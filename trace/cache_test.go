@@ -0,0 +1,150 @@
+package trace
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/log/logtest"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name        string
+		header      http.Header
+		request     http.Header
+		want        string
+		calls       int32
+		conditional int32
+	}{
+		{"MaxAge", http.Header{"Cache-Control": {"max-age=60"}}, nil, CacheHit, 1, 0},
+		{"Expires", http.Header{"Expires": {future}}, nil, CacheHit, 1, 0},
+		{"Expired", http.Header{"Expires": {past}}, nil, CacheMiss, 2, 0},
+		{"NoStore", http.Header{"Cache-Control": {"no-store, max-age=60"}}, nil, CacheMiss, 2, 0},
+		{"NoCache", http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}}, nil, CacheRevalidated, 2, 1},
+		{"ETag", http.Header{"Cache-Control": {"max-age=0"}, "Etag": {`"v1"`}}, nil, CacheRevalidated, 2, 1},
+		{"LastModified", http.Header{"Cache-Control": {"max-age=0"}, "Last-Modified": {""}}, nil, CacheRevalidated, 2, 1},
+		{"Heuristic", http.Header{"Last-Modified": {""}}, nil, CacheHit, 1, 0},
+		{"NoValidators", http.Header{}, nil, CacheMiss, 2, 0},
+		{"RequestNoCache", http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}},
+			http.Header{"Cache-Control": {"no-cache"}}, CacheRevalidated, 2, 1},
+		{"RequestMaxAge", http.Header{"Cache-Control": {"max-age=60"}},
+			http.Header{"Cache-Control": {"max-age=0"}}, CacheMiss, 2, 0},
+		{"VaryMatches", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}},
+			http.Header{"Accept-Language": {"sv"}}, CacheHit, 1, 0},
+		{"VaryAll", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, nil, CacheMiss, 2, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv, calls, conditional := cacheServer(t, tt.header)
+			client := newTestClient()
+			client.Cache = NewCache()
+
+			if resp, _ := get(t, client, srv.URL+"/countries", tt.request); resp.Header.Get(CacheHeader) != CacheMiss {
+				t.Errorf("Expected first response to be a miss got %q", resp.Header.Get(CacheHeader))
+			}
+			resp, body := get(t, client, srv.URL+"/countries", tt.request)
+			if status := resp.Header.Get(CacheHeader); status != tt.want || !strings.HasPrefix(body, "reference data") {
+				t.Errorf("Expected %s got %s %q", tt.want, status, body)
+			}
+			if *calls != tt.calls || *conditional != tt.conditional {
+				t.Errorf("Expected %d calls and %d conditional got %d and %d", tt.calls, tt.conditional, *calls, *conditional)
+			}
+		})
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	t.Parallel()
+	srv, calls, _ := cacheServer(t, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}})
+	client := newTestClient()
+	client.Cache = NewCache()
+
+	get(t, client, srv.URL, http.Header{"Accept-Language": {"sv"}})
+	resp, body := get(t, client, srv.URL, http.Header{"Accept-Language": {"en"}})
+	if status := resp.Header.Get(CacheHeader); status != CacheMiss || body != "reference data en" {
+		t.Errorf("Expected a miss for another language got %s %q", status, body)
+	}
+	resp, body = get(t, client, srv.URL, http.Header{"Accept-Language": {"en"}})
+	if status := resp.Header.Get(CacheHeader); status != CacheHit || body != "reference data en" {
+		t.Errorf("Expected a hit got %s %q", status, body)
+	}
+	if *calls != 2 {
+		t.Errorf("Expected 2 calls got %d", *calls)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	t.Parallel()
+	srv, calls, _ := cacheServer(t, http.Header{"Cache-Control": {"max-age=60"}})
+	client := newTestClient()
+	client.Cache = NewCache()
+
+	get(t, client, srv.URL+"/countries", nil)
+	resp, err := client.Post(srv.URL+"/countries", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, _ = get(t, client, srv.URL+"/countries", nil)
+	if status := resp.Header.Get(CacheHeader); status != CacheMiss || *calls != 3 {
+		t.Errorf("Expected a miss after POST got %s with %d calls", status, *calls)
+	}
+}
+
+func TestCacheLogAndMetrics(t *testing.T) {
+	t.Parallel()
+	srv, _, _ := cacheServer(t, http.Header{"Cache-Control": {"max-age=60"}})
+	rec := logtest.New(t)
+	client := newTestClient()
+	client.Cache = NewCache()
+	client.Logger = rec.Logger
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	get(t, client, srv.URL, nil)
+	get(t, client, srv.URL, nil)
+	rec.AssertLogged(log.InfoLevel, "outbound request", map[string]interface{}{"cache": CacheMiss})
+	rec.AssertLogged(log.InfoLevel, "outbound request", map[string]interface{}{"cache": CacheHit})
+
+	requests := client.Metrics.Counter("http_client_cache_requests_total", "", "host", "result")
+	if hits, misses := requests.Value(host, "hit"), requests.Value(host, "miss"); hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss got %v and %v", hits, misses)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+	entry := func(body string) *CachedResponse {
+		return &CachedResponse{StatusCode: 200, Body: []byte(body)}
+	}
+	s := NewMemoryStore(30)
+
+	s.Set("a", entry("0123456789"))
+	s.Set("b", entry("0123456789"))
+	s.Get("a")
+	s.Set("c", entry("0123456789"))
+	if _, ok := s.Get("b"); ok {
+		t.Errorf("Expected least recently used entry to be evicted")
+	}
+	if _, ok := s.Get("a"); !ok || s.Len() != 2 {
+		t.Errorf("Expected 2 entries got %d", s.Len())
+	}
+
+	s.Set("d", entry(strings.Repeat("x", 31)))
+	if _, ok := s.Get("d"); ok || s.Len() != 2 {
+		t.Errorf("Expected too large entry not to be stored")
+	}
+	s.Delete("a")
+	if _, ok := s.Get("a"); ok || s.Len() != 1 {
+		t.Errorf("Expected entry to be deleted")
+	}
+}
@@ -0,0 +1,119 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/wrapp/gokit/middleware/compressmw"
+)
+
+// Compression contains the settings of CompressionTransport. Request bodies of at least MinSize
+// bytes are compressed with gzip at Level, gzip.DefaultCompression is used if Level is 0. A
+// zero MinSize disables the compression of the requests. Decompressed response bodies are
+// limited to MaxDecompressedSize bytes, they are not limited if it is 0.
+type Compression struct {
+	MinSize             int64
+	Level               int
+	MaxDecompressedSize int64
+}
+
+// NewCompression creates a Compression which does not compress the requests and limits the
+// decompressed responses to compressmw.DefaultMaxSize.
+func NewCompression() *Compression {
+	return &Compression{MaxDecompressedSize: compressmw.DefaultMaxSize}
+}
+
+// CompressionTransport compresses the bodies of the requests with gzip and decompresses the
+// responses according to Compression. Request bodies which already have a `Content-Encoding`
+// are sent as they are, the service must accept compressed bodies e.g with compressmw. The
+// `Accept-Encoding` header is set to gzip and deflate and the responses are decompressed
+// transparently, unless the request already has an `Accept-Encoding` header. Reading a
+// response body beyond the limit fails with compressmw.ErrTooLarge.
+type CompressionTransport struct {
+	Next        http.RoundTripper
+	Compression *Compression
+}
+
+// RoundTrip compresses the request, performs it with the next transport and decompresses the
+// response.
+func (t *CompressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, err := t.compress(req)
+	if err != nil {
+		return nil, err
+	}
+	decompress := req.Header.Get("Accept-Encoding") == ""
+	if decompress {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", compressmw.AcceptEncoding)
+	}
+
+	resp, err := next(t.Next).RoundTrip(req)
+	if err != nil || !decompress {
+		return resp, err
+	}
+	encoding := resp.Header.Get("Content-Encoding")
+	if encoding == "" {
+		return resp, nil
+	}
+	body, err := compressmw.NewReader(resp.Body, encoding, t.Compression.MaxDecompressedSize)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body = body
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// compress returns the request with its body compressed if it is at least MinSize bytes.
+func (t *CompressionTransport) compress(req *http.Request) (*http.Request, error) {
+	c := t.Compression
+	if c.MinSize <= 0 || req.Body == nil || req.Body == http.NoBody ||
+		req.Header.Get("Content-Encoding") != "" || req.ContentLength > 0 && req.ContentLength < c.MinSize {
+		return req, nil
+	}
+	req, err := bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || int64(len(b)) < c.MinSize {
+		return req, err
+	}
+
+	var buf bytes.Buffer
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(b)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req.Body.Close()
+	compressed := buf.Bytes()
+	req = req.Clone(req.Context())
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.Body, _ = req.GetBody()
+	req.ContentLength = int64(len(compressed))
+	req.Header.Set("Content-Encoding", "gzip")
+	return req, nil
+}
@@ -0,0 +1,174 @@
+package trace
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wrapp/gokit/middleware/compressmw"
+)
+
+// compressed returns the bytes compressed with gzip, zlib or raw deflate.
+func compressed(encoding string, b []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	default:
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestCompressRequest(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body, _ = ioutil.ReadAll(zr)
+		}
+		w.Write([]byte(r.Header.Get("Content-Encoding") + " " + string(body)))
+	}))
+	t.Cleanup(srv.Close)
+	large := strings.Repeat("order ", 100)
+
+	tests := []struct {
+		name     string
+		minSize  int64
+		body     io.Reader
+		encoding string
+	}{
+		{"Large", 100, strings.NewReader(large), "gzip"},
+		{"UnknownLength", 100, ioutil.NopCloser(strings.NewReader(large)), "gzip"},
+		{"Small", 1000, strings.NewReader(large), ""},
+		{"Disabled", 0, strings.NewReader(large), ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			client := New(nil)
+			client.Compression = NewCompression()
+			client.Compression.MinSize = tt.minSize
+			resp, err := client.Post(srv.URL, "text/plain", tt.body)
+			if err != nil {
+				t.Fatalf("Request failed with %q", err)
+			}
+			defer resp.Body.Close()
+			if body, _ := ioutil.ReadAll(resp.Body); string(body) != tt.encoding+" "+large {
+				t.Errorf("Expected %q encoding got %.20q", tt.encoding, body)
+			}
+		})
+	}
+}
+
+func TestDecompressResponse(t *testing.T) {
+	t.Parallel()
+	payload := []byte(strings.Repeat(`{"name":"order"}`, 100))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("encoding")
+		if encoding == "gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+		} else {
+			w.Header().Set("Content-Encoding", "deflate")
+		}
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		if r.Method != "HEAD" {
+			w.Write(compressed(encoding, payload))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, encoding := range []string{"gzip", "zlib", "flate"} {
+		encoding := encoding
+		t.Run(encoding, func(t *testing.T) {
+			t.Parallel()
+			client := New(nil)
+			client.Compression = NewCompression()
+			resp, err := client.GetContext(context.Background(), srv.URL+"?encoding="+encoding)
+			if err != nil {
+				t.Fatalf("Request failed with %q", err)
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil || !bytes.Equal(body, payload) || resp.Header.Get("Content-Encoding") != "" {
+				t.Errorf("Expected decompressed body got %.20q, %v", body, err)
+			}
+			if ae := resp.Header.Get("X-Accept-Encoding"); ae != compressmw.AcceptEncoding {
+				t.Errorf("Expected Accept-Encoding %q got %q", compressmw.AcceptEncoding, ae)
+			}
+		})
+	}
+
+	t.Run("TooLarge", func(t *testing.T) {
+		t.Parallel()
+		client := New(nil)
+		client.Compression = NewCompression()
+		client.Compression.MaxDecompressedSize = 100
+		resp, err := client.GetContext(context.Background(), srv.URL+"?encoding=gzip")
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		defer resp.Body.Close()
+		if body, err := ioutil.ReadAll(resp.Body); !errors.Is(err, compressmw.ErrTooLarge) || len(body) != 100 {
+			t.Errorf("Expected the body to be limited to 100 bytes got %d, %v", len(body), err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		resp, err := New(nil).GetContext(context.Background(), srv.URL+"?encoding=gzip")
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil || !bytes.Equal(body, payload) || resp.Header.Get("X-Accept-Encoding") != "gzip" {
+			t.Errorf("Expected the response to be decompressed by the http.Transport got %.20q, %v", body, err)
+		}
+	})
+
+	t.Run("AcceptEncodingSet", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest("GET", srv.URL+"?encoding=gzip", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		client := New(nil)
+		client.Compression = NewCompression()
+		resp, err := client.DoContext(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Expected the response to be left compressed")
+		}
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		t.Parallel()
+		resp, err := New(nil).HeadContext(context.Background(), srv.URL+"?encoding=gzip")
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		resp.Body.Close()
+	})
+}
@@ -1,8 +1,16 @@
 // trace package provides a tracing http client. A trace client adds a unique request-id for outgoing
-// http request. It requires a func as a parameter which generates the request-id. A service would want to
-// generate a unique id for outgoing requests so that they can be traced in a large distributed systems
-// where different services are communicating. This package uses requestidmw middleware to add the request-id.
+// http request. It reads the request-id from the context.Context of the request or from a func which
+// generates the request-id. A service would want to generate a unique id for outgoing requests so that
+// they can be traced in a large distributed systems where different services are communicating. This
+// package uses requestidmw middleware to add the request-id.
 // In addition to request-id this client also sets the User-Agent header for each outgoing request. This
 // User-Agent is set to the value of SERVICE_NAME environment variable by default but it can be set to anything
 // by calling SetUserAgent method of TraceClient.
+// The package also records spans of outgoing requests with a Tracer and exports them to an OTLP/HTTP
+// collector in JSON encoding. See Tracer and Exporter.
+// All these behaviours are http.RoundTripper layers which can be used with a standard http.Client,
+// see NewTransport and NewHTTPClient. Logical urls of services e.g `svc://orders/orders/42` are
+// resolved and balanced across the endpoints of the service, see LoadBalancer. Responses can be
+// cached as a private HTTP cache, see CacheTransport. Slow idempotent requests can be hedged with
+// a second attempt, see HedgePolicy.
 package trace
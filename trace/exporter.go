@@ -0,0 +1,375 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wrapp/gokit/env"
+)
+
+// ExporterConfig contains the settings of an Exporter. Zero values are replaced with defaults.
+type ExporterConfig struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector e.g `http://collector:4318`. Spans are
+	// posted to `/v1/traces` of the endpoint.
+	Endpoint string
+	// Headers are added to every export request e.g for authentication.
+	Headers map[string]string
+	// QueueSize is the maximum number of spans waiting to be exported. Spans which do not fit in
+	// the queue are dropped. Defaults to 2048.
+	QueueSize int
+	// BatchSize is the maximum number of spans in an export request. Defaults to 512.
+	BatchSize int
+	// Interval is the maximum time a span waits in the queue. Defaults to 5s.
+	Interval time.Duration
+	// Timeout is the timeout of an export request. Defaults to 10s.
+	Timeout time.Duration
+}
+
+// ExporterConfigFromEnv returns the ExporterConfig from the standard OpenTelemetry environment
+// variables `OTEL_EXPORTER_OTLP_ENDPOINT`, `OTEL_EXPORTER_OTLP_TRACES_ENDPOINT`,
+// `OTEL_EXPORTER_OTLP_HEADERS`, `OTEL_BSP_MAX_QUEUE_SIZE`, `OTEL_BSP_MAX_EXPORT_BATCH_SIZE`,
+// `OTEL_BSP_SCHEDULE_DELAY` and `OTEL_BSP_EXPORT_TIMEOUT`. Delays are in milliseconds.
+func ExporterConfigFromEnv() ExporterConfig {
+	cfg := ExporterConfig{
+		Endpoint:  env.Get("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		Headers:   map[string]string{},
+		QueueSize: env.DefaultInt("OTEL_BSP_MAX_QUEUE_SIZE", 0),
+		BatchSize: env.DefaultInt("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", 0),
+		Interval:  time.Duration(env.DefaultInt("OTEL_BSP_SCHEDULE_DELAY", 0)) * time.Millisecond,
+		Timeout:   time.Duration(env.DefaultInt("OTEL_BSP_EXPORT_TIMEOUT", 0)) * time.Millisecond,
+	}
+	if e := env.Get("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); e != "" {
+		cfg.Endpoint = strings.TrimSuffix(e, "/v1/traces")
+	}
+	for _, kv := range strings.Split(env.Get("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			cfg.Headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return cfg
+}
+
+// Exporter exports ended spans in batches to an OTLP/HTTP collector in JSON encoding. Spans
+// are queued and exported in a separate goroutine when a batch is full or the interval has
+// passed. The queue is bounded, spans which do not fit are dropped and counted. Shutdown stops
+// the export goroutine.
+type Exporter struct {
+	cfg      ExporterConfig
+	client   *http.Client
+	queue    chan *Span
+	flushes  chan chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	shutdown sync.Once
+	dropped  uint64
+	failed   uint64
+
+	mu      sync.RWMutex
+	service string
+}
+
+// NewExporter creates an Exporter and starts its export goroutine.
+func NewExporter(cfg ExporterConfig) *Exporter {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 2048
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	e := &Exporter{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		queue:   make(chan *Span, cfg.QueueSize),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues the span to be exported. The span is dropped if the queue is full or the
+// exporter is shut down.
+func (e *Exporter) Export(s *Span) {
+	select {
+	case <-e.done:
+		atomic.AddUint64(&e.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case e.queue <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// Flush exports all the queued spans. It blocks until they are exported or the context is
+// done.
+func (e *Exporter) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case e.flushes <- ack:
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports all the queued spans and stops the export goroutine. It blocks until they
+// are exported or the context is done. Spans which end after Shutdown are dropped.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.shutdown.Do(func() { close(e.done) })
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dropped returns the number of spans which were dropped because the queue was full.
+func (e *Exporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Failed returns the number of spans which could not be exported.
+func (e *Exporter) Failed() uint64 {
+	return atomic.LoadUint64(&e.failed)
+}
+
+func (e *Exporter) setService(service string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.service = service
+}
+
+func (e *Exporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, e.cfg.BatchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			atomic.AddUint64(&e.failed, uint64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	drain := func() {
+		for {
+			select {
+			case s := <-e.queue:
+				batch = append(batch, s)
+				if len(batch) >= e.cfg.BatchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= e.cfg.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-e.flushes:
+			drain()
+			close(ack)
+		case <-e.done:
+			drain()
+			return
+		}
+	}
+}
+
+func (e *Exporter) send(batch []*Span) error {
+	e.mu.RLock()
+	service := e.service
+	e.mu.RUnlock()
+
+	body, err := json.Marshal(encodeSpans(service, batch))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(e.cfg.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The types below are the OTLP/HTTP JSON encoding of the spans. Trace and span ids are hex
+// encoded and 64 bit integers are encoded as strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func encodeSpans(service string, spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		es := otlpSpan{
+			TraceID:           s.spanContext.TraceID,
+			SpanID:            s.spanContext.SpanID,
+			ParentSpanID:      s.spanContext.ParentID,
+			TraceState:        s.spanContext.TraceState,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        encodeAttributes(s.attributes),
+			Status:            otlpStatus{Code: s.status, Message: s.statusMessage},
+		}
+		for _, ev := range s.events {
+			es.Events = append(es.Events, otlpEvent{
+				TimeUnixNano: unixNano(ev.Time),
+				Name:         ev.Name,
+				Attributes:   encodeAttributes(ev.Attributes),
+			})
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, es)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: encodeAttributes(map[string]interface{}{
+			"service.name": service,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/wrapp/gokit/trace"},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+func encodeAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: encodeValue(v)})
+	}
+	return kvs
+}
+
+func encodeValue(v interface{}) map[string]interface{} {
+	switch t := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": t}
+	case bool:
+		return map[string]interface{}{"boolValue": t}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(t), 10)}
+	case int32:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(t), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(t, 10)}
+	case float32:
+		return map[string]interface{}{"doubleValue": float64(t)}
+	case float64:
+		return map[string]interface{}{"doubleValue": t}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(t)}
+	}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,264 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wrapp/gokit/metrics"
+)
+
+const (
+	// hedgeSamples is the number of latencies of each host the hedge delay is computed from.
+	hedgeSamples = 256
+	// minHedgeSamples is the number of latencies needed before the percentile is used.
+	minHedgeSamples = 20
+	// hedgeBurst is the maximum number of hedges a host can save up in its budget.
+	hedgeBurst = 10
+)
+
+// HedgePolicy decides when a TraceClient sends a hedged request: a second attempt of a request
+// which has not responded in time. Only idempotent requests (GET, HEAD, OPTIONS, TRACE, PUT and
+// DELETE), or requests with an `Idempotency-Key` header, are hedged. The hedge is sent when the
+// first attempt has not responded within the Percentile of the recent latencies of the host, or
+// within Delay until enough latencies are known. The delay is kept between MinDelay and
+// MaxDelay, a zero MaxDelay does not limit it. The first response wins and the other attempt is
+// cancelled. If the hedge wins, the latency of the first attempt is recorded as the time it
+// waited. The hedges of each host are capped by a budget of MaxRatio of its requests. The
+// hedges, the hedges which won and the hedges denied by the budget of each host are exposed in
+// Metrics, or the default registry if it is nil.
+type HedgePolicy struct {
+	Percentile float64
+	Delay      time.Duration
+	MinDelay   time.Duration
+	MaxDelay   time.Duration
+	MaxRatio   float64
+	Metrics    *metrics.Registry
+
+	mu    sync.Mutex
+	hosts map[string]*hedgeHost
+}
+
+// hedgeHost is the state of a single host.
+type hedgeHost struct {
+	latencies [hedgeSamples]time.Duration
+	count     int
+	delay     time.Duration
+	budget    float64
+}
+
+// hedgeMetrics are the metrics of the hedged requests of a Registry.
+type hedgeMetrics struct {
+	hedges *metrics.Counter
+	wins   *metrics.Counter
+	denied *metrics.Counter
+}
+
+// NewHedgePolicy creates a HedgePolicy which hedges the requests which have not responded
+// within the 95th percentile of the latencies of their host, at least 10ms and 100ms until the
+// latencies are known, for at most 10% of the requests.
+func NewHedgePolicy() *HedgePolicy {
+	return &HedgePolicy{
+		Percentile: 0.95,
+		Delay:      100 * time.Millisecond,
+		MinDelay:   10 * time.Millisecond,
+		MaxRatio:   0.1,
+		Metrics:    metrics.Default(),
+	}
+}
+
+// Hedgeable reports whether the request can be hedged according to its method and headers.
+func (p *HedgePolicy) Hedgeable(req *http.Request) bool {
+	return idempotent(req)
+}
+
+// DelayFor returns the time to wait for a response from the host before the request is hedged.
+func (p *HedgePolicy) DelayFor(host string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.host(host).delay
+}
+
+// Do performs the request with the passed function and hedges it with a second attempt if it
+// does not respond in time. The attempt which responds first is returned, the other one is
+// cancelled.
+func (p *HedgePolicy) Do(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if !p.Hedgeable(req) {
+		return do(req)
+	}
+	req, err := bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+	host := req.URL.Host
+	m := p.metrics()
+	p.mu.Lock()
+	h := p.host(host)
+	h.budget += p.MaxRatio
+	if h.budget > hedgeBurst {
+		h.budget = hedgeBurst
+	}
+	delay := h.delay
+	p.mu.Unlock()
+
+	type attempt struct {
+		resp   *http.Response
+		err    error
+		hedge  bool
+		done   bool
+		start  time.Time
+		cancel context.CancelFunc
+	}
+	results := make(chan *attempt, 2)
+	send := func(a *attempt) {
+		ctx, cancel := context.WithCancel(req.Context())
+		a.cancel, a.start = cancel, time.Now()
+		r := req.Clone(ctx)
+		if a.hedge && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				a.err = err
+				results <- a
+				return
+			}
+			r.Body = body
+		}
+		go func() {
+			a.resp, a.err = do(r)
+			results <- a
+		}()
+	}
+
+	attempts := []*attempt{{}}
+	send(attempts[0])
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			if !p.spend(host) {
+				m.denied.Inc(host)
+				continue
+			}
+			m.hedges.Inc(host)
+			a := &attempt{hedge: true}
+			attempts = append(attempts, a)
+			pending++
+			send(a)
+		case a := <-results:
+			pending--
+			a.done = true
+			if a.err != nil && pending > 0 {
+				a.cancel()
+				continue
+			}
+			for _, other := range attempts {
+				if other == a {
+					continue
+				}
+				if !other.done && a.err == nil && other.start.Before(a.start) {
+					// the first attempt lost to its hedge, it is at least as slow as the time
+					// it has been waiting
+					p.observe(host, time.Since(other.start))
+				}
+				other.cancel()
+			}
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if loser := <-results; loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+				}
+			}(pending)
+			if a.err != nil {
+				a.cancel()
+				return nil, a.err
+			}
+			p.observe(host, time.Since(a.start))
+			if a.hedge {
+				m.wins.Inc(host)
+			}
+			a.resp.Body = &inflightBody{ReadCloser: a.resp.Body, done: a.cancel}
+			return a.resp, nil
+		}
+	}
+}
+
+// spend takes a hedge from the budget of the host, it reports false if the budget is used up.
+func (p *HedgePolicy) spend(host string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.host(host)
+	if h.budget < 1 {
+		return false
+	}
+	h.budget--
+	return true
+}
+
+// observe records the latency of an attempt to the host and updates its hedge delay. The
+// latency of a first attempt which lost to its hedge is the time it waited until it was
+// cancelled.
+func (p *HedgePolicy) observe(host string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.host(host)
+	h.latencies[h.count%hedgeSamples] = latency
+	h.count++
+	if h.count < minHedgeSamples {
+		return
+	}
+	n := h.count
+	if n > hedgeSamples {
+		n = hedgeSamples
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, h.latencies[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p.Percentile * float64(n))
+	if i >= n {
+		i = n - 1
+	}
+	h.delay = p.clamp(sorted[i])
+}
+
+func (p *HedgePolicy) clamp(delay time.Duration) time.Duration {
+	if delay < p.MinDelay {
+		delay = p.MinDelay
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// host returns the state of the host, p.mu must be held.
+func (p *HedgePolicy) host(host string) *hedgeHost {
+	if p.hosts == nil {
+		p.hosts = map[string]*hedgeHost{}
+	}
+	h, ok := p.hosts[host]
+	if !ok {
+		h = &hedgeHost{delay: p.clamp(p.Delay)}
+		p.hosts[host] = h
+	}
+	return h
+}
+
+func (p *HedgePolicy) metrics() hedgeMetrics {
+	r := p.Metrics
+	if r == nil {
+		r = metrics.Default()
+	}
+	return hedgeMetrics{
+		hedges: r.Counter("http_client_hedged_requests_total",
+			"Number of hedged requests sent to a host.", "host"),
+		wins: r.Counter("http_client_hedge_wins_total",
+			"Number of hedged requests to a host which responded before the first attempt.", "host"),
+		denied: r.Counter("http_client_hedge_denied_total",
+			"Number of hedged requests to a host which were not sent because the hedge budget was used up.", "host"),
+	}
+}
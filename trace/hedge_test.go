@@ -0,0 +1,132 @@
+package trace
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wrapp/gokit/metrics"
+)
+
+func newTestHedgePolicy(delay time.Duration, maxRatio float64) *HedgePolicy {
+	return &HedgePolicy{Percentile: 0.95, Delay: delay, MaxRatio: maxRatio, Metrics: metrics.NewRegistry()}
+}
+
+func TestHedge(t *testing.T) {
+	t.Parallel()
+	cancelled := make(chan struct{}, 1)
+	slow, fast := slowServer(t, time.Minute, cancelled), endpointServer(t, "fast", 200)
+	client := newTestClient()
+	client.Retry = nil
+	client.Balancer = NewLoadBalancer(staticResolver(slow.URL, fast.URL))
+	client.Hedge = newTestHedgePolicy(10*time.Millisecond, 1)
+
+	if _, body := get(t, client, "svc://orders/", nil); body != "fast /" {
+		t.Errorf("Expected the hedged request to win got %q", body)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the slow request to be cancelled")
+	}
+	m := client.Hedge.metrics()
+	if m.hedges.Value("orders") != 1 || m.wins.Value("orders") != 1 {
+		t.Errorf("Expected 1 hedge which won got %v, %v", m.hedges.Value("orders"), m.wins.Value("orders"))
+	}
+	client.Hedge.mu.Lock()
+	h := client.Hedge.hosts["orders"]
+	if h.count != 2 || h.latencies[0] < 10*time.Millisecond && h.latencies[1] < 10*time.Millisecond {
+		t.Errorf("Expected the latencies of both attempts, the cancelled one at least 10ms, got %v", h.latencies[:h.count])
+	}
+	client.Hedge.mu.Unlock()
+
+	t.Run("NotIdempotent", func(t *testing.T) {
+		t.Parallel()
+		srv := slowServer(t, 30*time.Millisecond, nil)
+		client := newTestClient()
+		client.Retry = nil
+		client.Balancer = nil
+		client.Hedge = newTestHedgePolicy(time.Millisecond, 1)
+		resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("order"))
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		resp.Body.Close()
+		host := strings.TrimPrefix(srv.URL, "http://")
+		if v := client.Hedge.metrics().hedges.Value(host); v != 0 {
+			t.Errorf("Expected POST not to be hedged got %v hedges", v)
+		}
+	})
+}
+
+func TestHedgeBudget(t *testing.T) {
+	t.Parallel()
+	srv := slowServer(t, 30*time.Millisecond, nil)
+	client := newTestClient()
+	client.Retry = nil
+	client.Balancer = nil
+	client.Hedge = newTestHedgePolicy(time.Millisecond, 0.5)
+
+	for i := 0; i < 4; i++ {
+		if _, body := get(t, client, srv.URL+"/orders", nil); body != "slow /orders" {
+			t.Errorf("Unexpected body %q", body)
+		}
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	m := client.Hedge.metrics()
+	if m.hedges.Value(host) != 2 || m.denied.Value(host) != 2 {
+		t.Errorf("Expected 2 hedges and 2 denied got %v, %v", m.hedges.Value(host), m.denied.Value(host))
+	}
+}
+
+func TestHedgeFirstAttemptWins(t *testing.T) {
+	t.Parallel()
+	srv := slowServer(t, 15*time.Millisecond, nil)
+	client := newTestClient()
+	client.Retry = nil
+	client.Hedge = newTestHedgePolicy(10*time.Millisecond, 1)
+	client.Hedge.Percentile = 0.5
+
+	for i := 0; i < 25; i++ {
+		if _, body := get(t, client, srv.URL+"/orders", nil); body != "slow /orders" {
+			t.Errorf("Unexpected body %q", body)
+		}
+	}
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if d := client.Hedge.DelayFor(host); d < 10*time.Millisecond {
+		t.Errorf("Expected the cancelled hedges not to lower the delay got %v", d)
+	}
+	client.Hedge.mu.Lock()
+	defer client.Hedge.mu.Unlock()
+	if n := client.Hedge.hosts[host].count; n != 25 {
+		t.Errorf("Expected only the latencies of the first attempts got %d", n)
+	}
+}
+
+func TestHedgeDelay(t *testing.T) {
+	t.Parallel()
+	p := newTestHedgePolicy(50*time.Millisecond, 0.1)
+	p.MinDelay = 5 * time.Millisecond
+	p.MaxDelay = 200 * time.Millisecond
+	if d := p.DelayFor("orders"); d != 50*time.Millisecond {
+		t.Errorf("Expected Delay until latencies are known got %v", d)
+	}
+	for i := 1; i <= 100; i++ {
+		p.observe("orders", time.Duration(i)*time.Millisecond)
+		p.observe("users", time.Duration(i)*time.Second)
+		p.observe("search", time.Duration(i)*time.Microsecond)
+	}
+	tests := []struct {
+		host string
+		want time.Duration
+	}{
+		{"orders", 96 * time.Millisecond},
+		{"users", 200 * time.Millisecond},
+		{"search", 5 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if d := p.DelayFor(tt.host); d != tt.want {
+			t.Errorf("Expected delay %v for %s got %v", tt.want, tt.host, d)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wrapp/gokit/metrics"
+)
+
+// newTestClient creates the TraceClient of the tests. It sends the request-id `request-1` and
+// the user-agent `test-agent`, its spans are not exported, its metrics are recorded in its own
+// registry and its retries wait at most 5ms. The tests set the layers they need on it.
+func newTestClient() *TraceClient {
+	client := New(func() string { return "request-1" })
+	client.UserAgent = "test-agent"
+	client.Tracer = NewTracer("test", nil, nil)
+	client.Metrics = metrics.NewRegistry()
+	client.Retry.BaseDelay = time.Millisecond
+	client.Retry.MaxDelay = 5 * time.Millisecond
+	return client
+}
+
+// get sends a GET request with the header through the client, and returns the response and
+// its body. It fails the test if the request fails.
+func get(t *testing.T, client *TraceClient, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed with %q", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func staticResolver(urls ...string) Resolver {
+	return ResolverFunc(func(context.Context, string) ([]*url.URL, error) {
+		return ParseEndpoints(urls)
+	})
+}
+
+// endpointServer responds with its name, the path and the query of the request.
+func endpointServer(t *testing.T, name string, status int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(name + " " + r.URL.RequestURI()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// slowServer responds after the delay, it reports on cancelled when the request is cancelled.
+func slowServer(t *testing.T, delay time.Duration, cancelled chan<- struct{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("slow " + r.URL.RequestURI()))
+		case <-r.Context().Done():
+			if cancelled != nil {
+				cancelled <- struct{}{}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// flakyServer responds with the status to the first `failures` requests and with 200 after.
+// It fails the test if a request arrives without the expected body.
+func flakyServer(t *testing.T, status int, failures int32, header http.Header) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if body, _ := ioutil.ReadAll(r.Body); string(body) != "payload" {
+				t.Errorf("Expected body \"payload\" got %q", body)
+			}
+		}
+		if atomic.AddInt32(&calls, 1) <= failures {
+			for k := range header {
+				w.Header().Set(k, header.Get(k))
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// cacheServer responds with the headers and counts the requests, and the conditional ones.
+func cacheServer(t *testing.T, header http.Header) (*httptest.Server, *int32, *int32) {
+	var calls, conditional int32
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		for k, vs := range header {
+			w.Header()[k] = vs
+		}
+		if _, ok := header["Last-Modified"]; ok {
+			w.Header().Set("Last-Modified", lastModified)
+		}
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			atomic.AddInt32(&conditional, 1)
+			if r.Header.Get("If-None-Match") == header.Get("ETag") || r.Header.Get("If-Modified-Since") == lastModified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write([]byte("reference data " + r.Header.Get("Accept-Language")))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls, &conditional
+}
+
+// echoRequest is the request received by requestEchoServer.
+type echoRequest struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Query     string `json:"query"`
+	RequestID string `json:"request_id"`
+	UserAgent string `json:"user_agent"`
+	Custom    string `json:"custom"`
+	Type      string `json:"content_type"`
+	Body      string `json:"body"`
+}
+
+// requestEchoServer responds with the request as an echoRequest, after 200ms for `/slow`.
+func requestEchoServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(echoRequest{
+			Method:    r.Method,
+			Path:      r.URL.EscapedPath(),
+			Query:     r.URL.RawQuery,
+			RequestID: r.Header.Get("X-Request-ID"),
+			UserAgent: r.UserAgent(),
+			Custom:    r.Header.Get("X-Custom"),
+			Type:      r.Header.Get("Content-Type"),
+			Body:      string(body),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/wrapp/gokit/middleware/requestidmw"
+)
+
+// DefaultMaxResponseSize is the maximum size of a response body read by the JSON helpers when
+// MaxResponseSize of the TraceClient is not set.
+const DefaultMaxResponseSize = 10 << 20
+
+// maxErrorBody is the maximum number of bytes of the body printed in a ResponseError message.
+const maxErrorBody = 512
+
+// ErrResponseTooLarge is returned by the JSON helpers when a response body is larger than the
+// maximum response size.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ResponseError is returned by the JSON helpers when the downstream service responds with a
+// non-2xx status. It implements errormw.StatusError. Body contains the response body, up to the
+// maximum response size. RequestID is the request-id of the downstream request, which can be
+// used to find its log entries.
+type ResponseError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+	RequestID  string
+}
+
+// Status returns the status code of the response.
+func (e *ResponseError) Status() int {
+	return e.StatusCode
+}
+
+// Error returns the request, the status and the beginning of the body of the response.
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%s %s responded with %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if len(e.Body) == 0 {
+		return msg
+	}
+	body := string(e.Body)
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody] + "..."
+	}
+	return msg + ": " + body
+}
+
+// GetJSON sends a GET request with the context and decodes the JSON response body into out. A
+// non-2xx response is returned as a *ResponseError. See RequestBuilder.Do and DecodeJSON for
+// more information.
+func (t *TraceClient) GetJSON(ctx context.Context, url string, out interface{}) error {
+	return t.doJSON(ctx, "GET", url, nil, out)
+}
+
+// PostJSON sends a POST request with in encoded as JSON and decodes the JSON response body into
+// out. See GetJSON for more information.
+func (t *TraceClient) PostJSON(ctx context.Context, url string, in, out interface{}) error {
+	return t.doJSON(ctx, "POST", url, in, out)
+}
+
+// PutJSON sends a PUT request with in encoded as JSON and decodes the JSON response body into
+// out. See GetJSON for more information.
+func (t *TraceClient) PutJSON(ctx context.Context, url string, in, out interface{}) error {
+	return t.doJSON(ctx, "PUT", url, in, out)
+}
+
+// PatchJSON sends a PATCH request with in encoded as JSON and decodes the JSON response body
+// into out. See GetJSON for more information.
+func (t *TraceClient) PatchJSON(ctx context.Context, url string, in, out interface{}) error {
+	return t.doJSON(ctx, "PATCH", url, in, out)
+}
+
+// doJSON performs a request with in encoded as JSON, the body is omitted if in is nil, and
+// decodes the response body into out.
+func (t *TraceClient) doJSON(ctx context.Context, method, url string, in, out interface{}) error {
+	b := t.NewRequest(method, url)
+	if in != nil {
+		b.JSON(in)
+	}
+	return b.DecodeJSON(ctx, out)
+}
+
+func (t *TraceClient) maxResponseSize() int64 {
+	if t.MaxResponseSize <= 0 {
+		return DefaultMaxResponseSize
+	}
+	return t.MaxResponseSize
+}
+
+// readBody reads the response body up to the maximum response size. The second return value
+// reports whether the body was larger, in which case it is truncated.
+func (t *TraceClient) readBody(resp *http.Response) ([]byte, bool, error) {
+	max := t.maxResponseSize()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if int64(len(b)) > max {
+		return b[:max], true, err
+	}
+	return b, false, err
+}
+
+// responseError returns a *ResponseError for the response. The request-id of the downstream
+// request is read from the response and falls back to the one which was sent.
+func (t *TraceClient) responseError(req *http.Request, resp *http.Response) *ResponseError {
+	body, _, _ := t.readBody(resp)
+	id := requestidmw.IDFromHeader(resp.Header)
+	if id == "" {
+		id = requestidmw.IDFromHeader(req.Header)
+	}
+	return &ResponseError{Method: req.Method, URL: requestTarget(req), StatusCode: resp.StatusCode, Body: body, RequestID: id}
+}
+
+// requestTarget returns the url of the request without the query, which can contain secrets.
+func requestTarget(req *http.Request) string {
+	return (&url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: req.URL.Path}).String()
+}
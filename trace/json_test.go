@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wrapp/gokit/middleware/errormw"
+)
+
+type order struct {
+	ID    int    `json:"id"`
+	Items int    `json:"items"`
+	Name  string `json:"name,omitempty"`
+}
+
+func jsonServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orders":
+			var in order
+			if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&in) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			in.ID = 42
+			in.Name = r.Method
+			json.NewEncoder(w).Encode(in)
+		case "/missing":
+			w.Header().Set("X-Request-ID", "downstream-id")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"order not found"}`))
+		case "/invalid":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>"))
+		case "/large":
+			w.Write([]byte(`"` + strings.Repeat("a", 100) + `"`))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestJSONHelpers(t *testing.T) {
+	t.Parallel()
+	srv := jsonServer(t)
+	client := New(nil)
+	client.Tracer = NewTracer("test", nil, nil)
+	ctx := context.Background()
+
+	helpers := map[string]func(ctx context.Context, url string, in, out interface{}) error{
+		"POST":  client.PostJSON,
+		"PUT":   client.PutJSON,
+		"PATCH": client.PatchJSON,
+	}
+	for method, helper := range helpers {
+		var out order
+		if err := helper(ctx, srv.URL+"/orders", order{Items: 3}, &out); err != nil {
+			t.Fatalf("%s failed with %q", method, err)
+		}
+		if out != (order{ID: 42, Items: 3, Name: method}) {
+			t.Errorf("%s decoded %+v", method, out)
+		}
+	}
+
+	if err := client.GetJSON(ctx, srv.URL+"/empty", &order{}); err != nil {
+		t.Errorf("Expected empty response to be ignored got %q", err)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	t.Parallel()
+	srv := jsonServer(t)
+	client := New(nil)
+	client.Tracer = NewTracer("test", nil, nil)
+	client.MaxResponseSize = 50
+	ctx := context.Background()
+
+	err := client.GetJSON(ctx, srv.URL+"/missing", nil)
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) {
+		t.Fatalf("Expected ResponseError got %v", err)
+	}
+	if respErr.RequestID != "downstream-id" || string(respErr.Body) != `{"error":"order not found"}` {
+		t.Errorf("Unexpected ResponseError %+v", respErr)
+	}
+	var statusErr errormw.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status() != http.StatusNotFound {
+		t.Errorf("Expected ResponseError to be a StatusError with status 404 got %v", err)
+	}
+	if !strings.Contains(err.Error(), "GET "+srv.URL+"/missing responded with 404 Not Found") {
+		t.Errorf("Unexpected error message %q", err)
+	}
+
+	var s string
+	err = client.GetJSON(ctx, srv.URL+"/invalid", &s)
+	if err == nil || !strings.Contains(err.Error(), `with status 200 and content type "text/html"`) {
+		t.Errorf("Expected decoding error with context got %v", err)
+	}
+
+	if err = client.GetJSON(ctx, srv.URL+"/large", &s); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("Expected ErrResponseTooLarge got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/wrapp/gokit/metrics"
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+const pathTemplateKey = "path_template"
+
+// maxLoggedBody is the maximum number of bytes of a body which are logged.
+const maxLoggedBody = 4096
+
+// idSegment matches path segments which are ids: numbers, UUIDs and long hex strings.
+var idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// ContextWithPathTemplate returns a context.Context which sets the path template, e.g
+// `/orders/{id}`, that is logged for the requests made with it instead of the path derived by
+// PathTemplate.
+func ContextWithPathTemplate(ctx context.Context, template string) context.Context {
+	return wrpctx.NewWithValue(ctx, pathTemplateKey, template)
+}
+
+// PathTemplate returns the path with the segments which look like ids, i.e numbers, UUIDs and
+// long hex strings, replaced by `{id}`. This groups the requests to the same endpoint.
+func PathTemplate(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if idSegment.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// clientMetrics are the metrics of the outgoing requests of a Registry.
+type clientMetrics struct {
+	duration     *metrics.Histogram
+	requestSize  *metrics.Histogram
+	responseSize *metrics.Histogram
+}
+
+var sizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+
+func newClientMetrics(r *metrics.Registry) clientMetrics {
+	if r == nil {
+		r = metrics.Default()
+	}
+	return clientMetrics{
+		duration: r.Histogram("http_client_request_duration_seconds",
+			"Duration of outgoing requests including retries.", nil, "host", "method", "code"),
+		requestSize: r.Histogram("http_client_request_size_bytes",
+			"Size of the body of outgoing requests.", sizeBuckets, "host", "method"),
+		responseSize: r.Histogram("http_client_response_size_bytes",
+			"Size of the body of the responses of outgoing requests.", sizeBuckets, "host", "method"),
+	}
+}
+
+// requestBody returns the logged body of the request if it can be read again.
+func requestBody(req *http.Request) interface{} {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(body, maxLoggedBody+1))
+	return loggedBody(b)
+}
+
+// responseBody returns the logged body of the response. The read part of the body is put back
+// so that the caller can read the whole body.
+func responseBody(resp *http.Response) interface{} {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxLoggedBody+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+	return loggedBody(b)
+}
+
+// loggedBody returns a JSON body as an object, so that the redactor masks it by key, and any
+// other body as a string. Bodies longer than maxLoggedBody are truncated.
+func loggedBody(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	if len(b) > maxLoggedBody {
+		return string(b[:maxLoggedBody]) + "...(truncated)"
+	}
+	var v interface{}
+	if json.Unmarshal(b, &v) == nil {
+		return v
+	}
+	return string(b)
+}
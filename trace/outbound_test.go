@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/log/logtest"
+	"github.com/wrapp/gokit/metrics"
+)
+
+func TestOutboundLogging(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token":"abc","name":"order"}`))
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	tests := []struct {
+		name      string
+		logBodies bool
+	}{
+		{"WithoutBodies", false},
+		{"WithBodies", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rec := logtest.New(t)
+			client := New(func() string { return "request-1" })
+			client.Tracer = NewTracer("test", nil, nil)
+			client.Logger = rec.Logger
+			client.LogBodies = tt.logBodies
+			client.Metrics = metrics.NewRegistry()
+
+			resp, err := client.Post(srv.URL+"/orders/42/items", "application/json", strings.NewReader(`{"password":"secret"}`))
+			if err != nil {
+				t.Fatalf("Request failed with %q", err)
+			}
+			defer resp.Body.Close()
+			if body, _ := ioutil.ReadAll(resp.Body); string(body) != `{"token":"abc","name":"order"}` {
+				t.Errorf("Expected the whole response body to be readable got %q", body)
+			}
+
+			rec.AssertLogged(log.InfoLevel, "outbound request", map[string]interface{}{
+				"method":         "POST",
+				"host":           host,
+				"path":           "/orders/{id}/items",
+				"status":         200,
+				"retries":        0,
+				"request_id":     "request-1",
+				"request_bytes":  21,
+				"response_bytes": 30,
+			})
+			entries := rec.Find(log.InfoLevel, "outbound request", nil)
+			if len(entries) != 1 {
+				t.Fatalf("Expected 1 entry got %d", len(entries))
+			}
+			fields := entries[0].Fields
+			if _, ok := fields["duration_ms"]; !ok {
+				t.Errorf("Expected duration_ms to be logged")
+			}
+			if !tt.logBodies {
+				if _, ok := fields["request_body"]; ok {
+					t.Errorf("Expected bodies not to be logged by default")
+				}
+				return
+			}
+			reqBody, _ := fields["request_body"].(map[string]interface{})
+			respBody, _ := fields["response_body"].(map[string]interface{})
+			if reqBody["password"] != "[REDACTED]" || respBody["token"] != "[REDACTED]" || respBody["name"] != "order" {
+				t.Errorf("Expected the bodies to be redacted got %v and %v", reqBody, respBody)
+			}
+		})
+	}
+}
+
+func TestOutboundMetrics(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	client := New(nil)
+	client.Tracer = NewTracer("test", nil, nil)
+	client.Metrics = metrics.NewRegistry()
+	for i := 0; i < 2; i++ {
+		resp, err := client.GetContext(ContextWithPathTemplate(context.Background(), "/orders/{id}"), srv.URL+"/orders/1")
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		resp.Body.Close()
+	}
+
+	if n := newClientMetrics(client.Metrics).duration.Count(host, "GET", "404"); n != 2 {
+		t.Errorf("Expected 2 observed durations got %d", n)
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	t.Parallel()
+	tests := map[string]string{
+		"/orders":    "/orders",
+		"/orders/42": "/orders/{id}",
+		"/users/6ba7b810-9dad-11d1-80b4-00c04fd430c8/cart": "/users/{id}/cart",
+		"/blobs/0123456789abcdef0123":                      "/blobs/{id}",
+		"/v1/items/abc":                                    "/v1/items/abc",
+	}
+	for path, want := range tests {
+		if got := PathTemplate(path); got != want {
+			t.Errorf("PathTemplate(%q) = %q wanted %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+const retryPolicyKey = "retry_policy"
+
+// BodyEncoder encodes a value into a request body and returns the body and its content type.
+type BodyEncoder func(v interface{}) ([]byte, string, error)
+
+// JSONEncoder is a BodyEncoder which encodes the value as JSON.
+func JSONEncoder(v interface{}) ([]byte, string, error) {
+	b, err := json.Marshal(v)
+	return b, "application/json", err
+}
+
+// ContextWithRetryPolicy returns a context.Context which overrides the RetryPolicy of the
+// TraceClient for the requests made with it. A nil policy disables the retries.
+func ContextWithRetryPolicy(ctx context.Context, p *RetryPolicy) context.Context {
+	return wrpctx.NewWithValue(ctx, retryPolicyKey, p)
+}
+
+// RequestBuilder builds a request step by step and performs it through the TraceClient, so
+// the request goes through the same request-id, user-agent, tracing, retry and logging logic
+// as Do. It is created with NewRequest. Errors which happen while building, e.g when encoding
+// the body, are returned by Do. The body is buffered so that a builder can be sent more than
+// once, and every attempt of the request sends the whole body.
+type RequestBuilder struct {
+	client   *TraceClient
+	method   string
+	url      string
+	params   map[string]string
+	query    url.Values
+	header   http.Header
+	body     []byte
+	expected []int
+	timeout  time.Duration
+	retry    *RetryPolicy
+	retrySet bool
+	err      error
+}
+
+// NewRequest creates a RequestBuilder for a request with the method to the url. The url can
+// contain path parameters in braces e.g `http://orders/orders/{id}` which are set with
+// PathParam.
+func (t *TraceClient) NewRequest(method, rawURL string) *RequestBuilder {
+	return &RequestBuilder{
+		client: t,
+		method: method,
+		url:    rawURL,
+		params: map[string]string{},
+		query:  url.Values{},
+		header: http.Header{},
+	}
+}
+
+// PathParam sets the value of the path parameter `{name}` of the url. The value is escaped.
+func (b *RequestBuilder) PathParam(name, value string) *RequestBuilder {
+	b.params[name] = value
+	return b
+}
+
+// Query adds a query value to the url.
+func (b *RequestBuilder) Query(key, value string) *RequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+// Header sets a header of the request.
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	b.header.Set(key, value)
+	return b
+}
+
+// Body sets the body of the request and its content type. The body is read into memory.
+func (b *RequestBuilder) Body(contentType string, body io.Reader) *RequestBuilder {
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		b.err = fmt.Errorf("reading request body of %s %s: %w", b.method, b.url, err)
+		return b
+	}
+	b.body = buf
+	b.header.Set("Content-Type", contentType)
+	return b
+}
+
+// Encode sets the body of the request to the value encoded by the encoder.
+func (b *RequestBuilder) Encode(v interface{}, encoder BodyEncoder) *RequestBuilder {
+	body, contentType, err := encoder(v)
+	if err != nil {
+		b.err = fmt.Errorf("encoding request body of %s %s: %w", b.method, b.url, err)
+		return b
+	}
+	return b.Body(contentType, bytes.NewReader(body))
+}
+
+// JSON sets the body of the request to the value encoded as JSON.
+func (b *RequestBuilder) JSON(v interface{}) *RequestBuilder {
+	return b.Encode(v, JSONEncoder)
+}
+
+// Form sets the body of the request to the form values.
+func (b *RequestBuilder) Form(values url.Values) *RequestBuilder {
+	return b.Body("application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+}
+
+// ExpectStatus sets the status codes which are expected in the response. Do returns a
+// *ResponseError for any other status.
+func (b *RequestBuilder) ExpectStatus(codes ...int) *RequestBuilder {
+	b.expected = append(b.expected, codes...)
+	return b
+}
+
+// Timeout sets the timeout of the request, including the retries and reading the body.
+func (b *RequestBuilder) Timeout(d time.Duration) *RequestBuilder {
+	b.timeout = d
+	return b
+}
+
+// Retry overrides the RetryPolicy of the TraceClient for the request. A nil policy disables
+// the retries.
+func (b *RequestBuilder) Retry(p *RetryPolicy) *RequestBuilder {
+	b.retry = p
+	b.retrySet = true
+	return b
+}
+
+// Build returns the http.Request with the context.Context.
+func (b *RequestBuilder) Build(ctx context.Context) (*http.Request, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	rawURL := b.url
+	for name, value := range b.params {
+		rawURL = strings.Replace(rawURL, "{"+name+"}", url.PathEscape(value), -1)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.query) > 0 {
+		q := u.Query()
+		for k, vs := range b.query {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	if b.retrySet {
+		ctx = ContextWithRetryPolicy(ctx, b.retry)
+	}
+	var body io.Reader
+	if b.body != nil {
+		body = bytes.NewReader(b.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, b.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range b.header {
+		req.Header[k] = append([]string{}, vs...)
+	}
+	return req, nil
+}
+
+// Do performs the request with the context through the Do method of the TraceClient, so the
+// request-id is read from the context unless RequestIDFunc is set. If ExpectStatus was set
+// then a response with another status is returned as a *ResponseError.
+func (b *RequestBuilder) Do(ctx context.Context) (*http.Response, error) {
+	_, resp, err := b.do(ctx)
+	return resp, err
+}
+
+// DecodeJSON performs the request and decodes the JSON response body into out. A response
+// with an unexpected status, any non-2xx status if ExpectStatus was not set, is returned as a
+// *ResponseError. The body is read up to MaxResponseSize of the TraceClient, a larger body
+// fails with ErrResponseTooLarge. It is decoded into out unless out is nil or the body is
+// empty. Decoding errors contain the request, the status and the content type. The builder is
+// not changed, so it can still be sent with Do.
+func (b *RequestBuilder) DecodeJSON(ctx context.Context, out interface{}) error {
+	c := *b
+	c.header = b.header.Clone()
+	if c.header.Get("Accept") == "" {
+		c.header.Set("Accept", "application/json")
+	}
+	if len(c.expected) == 0 {
+		for code := 200; code < 300; code++ {
+			c.expected = append(c.expected, code)
+		}
+	}
+	req, resp, err := c.do(ctx)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, tooLarge, err := b.client.readBody(resp)
+	target := requestTarget(req)
+	if err != nil {
+		return fmt.Errorf("reading response body of %s %s: %w", req.Method, target, err)
+	}
+	if tooLarge {
+		return fmt.Errorf("%s %s: %w, limit is %d bytes", req.Method, target, ErrResponseTooLarge, b.client.maxResponseSize())
+	}
+	if out == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body of %s %s with status %d and content type %q: %w",
+			req.Method, target, resp.StatusCode, resp.Header.Get("Content-Type"), err)
+	}
+	return nil
+}
+
+func (b *RequestBuilder) do(ctx context.Context) (*http.Request, *http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if b.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+	}
+	req, err := b.Build(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		cancel()
+		return req, nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	if len(b.expected) > 0 && !containsStatus(b.expected, resp.StatusCode) {
+		defer resp.Body.Close()
+		return req, nil, b.client.responseError(req, resp)
+	}
+	return req, resp, nil
+}
+
+func containsStatus(codes []int, status int) bool {
+	for _, c := range codes {
+		if c == status {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelBody cancels the timeout of the request when the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,158 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerbs(t *testing.T) {
+	t.Parallel()
+	srv := requestEchoServer(t)
+	client := newTestClient()
+
+	verbs := map[string]func() (*http.Response, error){
+		"PUT":     func() (*http.Response, error) { return client.Put(srv.URL, "text/plain", strings.NewReader("body")) },
+		"PATCH":   func() (*http.Response, error) { return client.Patch(srv.URL, "text/plain", strings.NewReader("body")) },
+		"DELETE":  func() (*http.Response, error) { return client.Delete(srv.URL) },
+		"OPTIONS": func() (*http.Response, error) { return client.Options(srv.URL) },
+	}
+	for method, do := range verbs {
+		resp, err := do()
+		if err != nil {
+			t.Fatalf("%s failed with %q", method, err)
+		}
+		var got echoRequest
+		json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if got.Method != method || got.RequestID != "request-1" || got.UserAgent != "test-agent" {
+			t.Errorf("Unexpected %s request %+v", method, got)
+		}
+		if (method == "PUT" || method == "PATCH") && (got.Body != "body" || got.Type != "text/plain") {
+			t.Errorf("Expected %s body to be sent got %+v", method, got)
+		}
+	}
+}
+
+func TestRequestBuilder(t *testing.T) {
+	t.Parallel()
+	srv := requestEchoServer(t)
+	client := newTestClient()
+
+	var got echoRequest
+	err := client.NewRequest("POST", srv.URL+"/orders/{id}/items").
+		PathParam("id", "a/b c").
+		Query("expand", "items").
+		Query("expand", "user").
+		Header("X-Custom", "value").
+		JSON(map[string]int{"items": 3}).
+		ExpectStatus(http.StatusCreated).
+		DecodeJSON(context.Background(), &got)
+	if err != nil {
+		t.Fatalf("Request failed with %q", err)
+	}
+	expected := echoRequest{
+		Method:    "POST",
+		Path:      "/orders/a%2Fb%20c/items",
+		Query:     "expand=items&expand=user",
+		RequestID: "request-1",
+		UserAgent: "test-agent",
+		Custom:    "value",
+		Type:      "application/json",
+		Body:      `{"items":3}`,
+	}
+	if got != expected {
+		t.Errorf("Expected %+v got %+v", expected, got)
+	}
+
+	_, err = client.NewRequest("DELETE", srv.URL).ExpectStatus(http.StatusNoContent).Do(context.Background())
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) || respErr.Status() != http.StatusCreated {
+		t.Errorf("Expected ResponseError for unexpected status got %v", err)
+	}
+
+	_, err = client.NewRequest("GET", srv.URL).JSON(make(chan int)).Do(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "encoding request body of GET") {
+		t.Errorf("Expected encoding error got %v", err)
+	}
+}
+
+func TestRequestBuilderDecodeJSON(t *testing.T) {
+	t.Parallel()
+	srv := requestEchoServer(t)
+	b := newTestClient().NewRequest("GET", srv.URL)
+
+	var got echoRequest
+	if err := b.DecodeJSON(context.Background(), &got); err != nil || got.Method != "GET" {
+		t.Errorf("Request failed with %v", err)
+	}
+	if len(b.expected) != 0 || b.header.Get("Accept") != "" {
+		t.Errorf("Expected DecodeJSON not to change the builder got %v, %v", b.expected, b.header)
+	}
+}
+
+func TestRequestBuilderTimeout(t *testing.T) {
+	t.Parallel()
+	srv := requestEchoServer(t)
+	client := newTestClient()
+
+	_, err := client.NewRequest("GET", srv.URL+"/slow").Timeout(20 * time.Millisecond).Do(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to time out got %v", err)
+	}
+
+	resp, err := client.NewRequest("GET", srv.URL).Timeout(time.Second).Do(context.Background())
+	if err != nil {
+		t.Fatalf("Request failed with %q", err)
+	}
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Errorf("Expected the body to be readable within the timeout got %q", err)
+	}
+}
+
+func TestRequestBuilderRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		retry *RetryPolicy
+		calls int32
+	}{
+		{"Disabled", nil, 1},
+		{"Override", &RetryPolicy{MaxRetries: 1, StatusCodes: []int{http.StatusServiceUnavailable}}, 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv, calls := flakyServer(t, http.StatusServiceUnavailable, 10, nil)
+			resp, err := newTestClient().NewRequest("GET", srv.URL).Retry(tt.retry).Do(context.Background())
+			if err != nil {
+				t.Fatalf("Request failed with %q", err)
+			}
+			resp.Body.Close()
+			if *calls != tt.calls {
+				t.Errorf("Expected %d requests got %d", tt.calls, *calls)
+			}
+		})
+	}
+}
+
+func TestRequestBuilderResend(t *testing.T) {
+	t.Parallel()
+	srv := requestEchoServer(t)
+	b := newTestClient().NewRequest("PUT", srv.URL).Body("text/plain", strings.NewReader("order"))
+
+	for i := 0; i < 2; i++ {
+		var got echoRequest
+		if err := b.DecodeJSON(context.Background(), &got); err != nil || got.Body != "order" {
+			t.Errorf("Expected the body to be sent again got %q, %v", got.Body, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/wrapp/gokit/env"
+)
+
+// ServiceScheme is the scheme of the logical urls of services e.g `svc://orders/orders/42`. The
+// host of such a url is the name of the service, which is resolved to its endpoints by a
+// LoadBalancer.
+const ServiceScheme = "svc"
+
+// ErrNoEndpoints is the error of a ResolveError when a service has no endpoints.
+var ErrNoEndpoints = errors.New("no endpoints")
+
+// ResolveError is returned for requests to a service whose endpoints cannot be resolved.
+type ResolveError struct {
+	Service string
+	Err     error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("cannot resolve service %s: %s", e.Service, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// Resolver resolves the name of a service to the base urls of its endpoints e.g
+// `http://10.0.0.1:8080`. The path of a base url is prepended to the path of the requests.
+type Resolver interface {
+	Resolve(ctx context.Context, service string) ([]*url.URL, error)
+}
+
+// ResolverFunc is a function which implements Resolver.
+type ResolverFunc func(ctx context.Context, service string) ([]*url.URL, error)
+
+// Resolve calls the function.
+func (f ResolverFunc) Resolve(ctx context.Context, service string) ([]*url.URL, error) {
+	return f(ctx, service)
+}
+
+// EnvResolver resolves services from environment variables with the upper case name of the
+// service, with `-` and `.` replaced by `_`, and the `_URLS` suffix. The variable contains the
+// comma separated urls of the endpoints e.g `ORDERS_URLS=http://10.0.0.1:8080,http://10.0.0.2:8080`.
+type EnvResolver struct{}
+
+// Resolve reads the urls of the service from its environment variable.
+func (EnvResolver) Resolve(_ context.Context, service string) ([]*url.URL, error) {
+	return ParseEndpoints(strings.Split(env.Get(EnvResolverKey(service)), ","))
+}
+
+// EnvResolverKey returns the name of the environment variable of the service for EnvResolver.
+func EnvResolverKey(service string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(service)) + "_URLS"
+}
+
+// DNSResolver resolves services from DNS SRV records. The name of the service, followed by
+// Domain if it is set, is looked up as `_Service._Proto.name` or, if Service and Proto are
+// empty, as it is e.g `orders.service.consul`. Only the records with the highest priority are
+// used. The urls of the endpoints have the Scheme, `http` by default. The default resolver of the
+// net package is used if Resolver is nil.
+type DNSResolver struct {
+	Scheme   string
+	Service  string
+	Proto    string
+	Domain   string
+	Resolver *net.Resolver
+}
+
+// Resolve looks up the SRV records of the service.
+func (r DNSResolver) Resolve(ctx context.Context, service string) ([]*url.URL, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	name := service
+	if r.Domain != "" {
+		name += "." + strings.TrimPrefix(r.Domain, ".")
+	}
+	_, records, err := resolver.LookupSRV(ctx, r.Service, r.Proto, name)
+	if err != nil {
+		return nil, err
+	}
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	var endpoints []*url.URL
+	for _, srv := range records {
+		// The records are sorted by priority, lower values first.
+		if srv.Priority != records[0].Priority {
+			break
+		}
+		host := strings.TrimSuffix(srv.Target, ".")
+		endpoints = append(endpoints, &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))})
+	}
+	return endpoints, nil
+}
+
+// FileResolver resolves services from a static JSON file which maps the names of the services
+// to the urls of their endpoints e.g `{"orders": ["http://10.0.0.1:8080"]}`. The file is read
+// on each resolution so that changes are picked up, see LoadBalancer.RefreshInterval.
+type FileResolver struct {
+	Path string
+}
+
+// Resolve reads the urls of the service from the file.
+func (r FileResolver) Resolve(_ context.Context, service string) ([]*url.URL, error) {
+	b, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	var services map[string][]string
+	if err := json.Unmarshal(b, &services); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", r.Path, err)
+	}
+	return ParseEndpoints(services[service])
+}
+
+// ParseEndpoints parses the urls of endpoints. Empty values are skipped, the urls must be
+// absolute.
+func ParseEndpoints(values []string) ([]*url.URL, error) {
+	var endpoints []*url.URL
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("endpoint %q is not an absolute url", v)
+		}
+		endpoints = append(endpoints, u)
+	}
+	return endpoints, nil
+}
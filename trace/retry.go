@@ -0,0 +1,122 @@
+package trace
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// idempotencyKeyHeader marks a request of a non-idempotent method as safe to retry.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// RetryPolicy decides which requests of a TraceClient are retried and how long to wait between
+// the attempts. Only requests with an idempotent method (GET, HEAD, OPTIONS, TRACE, PUT and
+// DELETE), or with an `Idempotency-Key` header, are retried unless RetryNonIdempotent is set.
+// A request is retried if it failed with a network error or responded with one of StatusCodes.
+// The wait between the attempts grows exponentially from BaseDelay up to MaxDelay with a random
+// jitter. A `Retry-After` header of the response is honoured if it asks for a longer wait.
+// No retry is made if the wait would go past the deadline of the context of the request.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries after the first attempt.
+	MaxRetries int
+	// StatusCodes are the response status codes which are retried.
+	StatusCodes []int
+	// BaseDelay is the wait before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum wait between two attempts, without `Retry-After`.
+	MaxDelay time.Duration
+	// RetryNonIdempotent makes requests of every method retryable.
+	RetryNonIdempotent bool
+}
+
+// NewRetryPolicy creates a RetryPolicy which retries 3 times on network errors and on the
+// status codes 429, 502, 503 and 504, waiting from 100ms up to 5s between the attempts.
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: 3,
+		StatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  5 * time.Second,
+	}
+}
+
+// Retryable reports whether the request can be retried according to its method and headers.
+func (p *RetryPolicy) Retryable(req *http.Request) bool {
+	return p.RetryNonIdempotent || idempotent(req)
+}
+
+// idempotent reports whether the request has an idempotent method or an `Idempotency-Key`.
+func idempotent(req *http.Request) bool {
+	if req.Header.Get(idempotencyKeyHeader) != "" {
+		return true
+	}
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// ShouldRetry reports whether an attempt which returned the response or the error should be
+// retried. Errors caused by the context of the request being done, by an open circuit breaker
+// or by a full bulkhead are not retried.
+func (p *RetryPolicy) ShouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	var open *CircuitOpenError
+	var full *BulkheadFullError
+	if req.Context().Err() != nil || errors.As(err, &open) || errors.As(err, &full) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	for _, code := range p.StatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns the wait before the retry with the passed number, starting at 1. The response
+// of the previous attempt can be nil.
+func (p *RetryPolicy) Delay(retry int, resp *http.Response) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// the jitter spreads the retries of concurrent clients between half and the full delay
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	if resp != nil {
+		if after := retryAfter(resp.Header.Get("Retry-After")); after > delay {
+			delay = after
+		}
+	}
+	return delay
+}
+
+// retryAfter parses the `Retry-After` header, which is either a number of seconds or a date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		method   string
+		header   http.Header
+		status   int
+		failures int32
+		want     int
+		calls    int32
+	}{
+		{"RetriesGet", "GET", nil, http.StatusServiceUnavailable, 2, http.StatusOK, 3},
+		{"GivesUp", "GET", nil, http.StatusBadGateway, 10, http.StatusBadGateway, 4},
+		{"NoRetryOnOtherStatus", "GET", nil, http.StatusInternalServerError, 1, http.StatusInternalServerError, 1},
+		{"NoRetryOnPost", "POST", nil, http.StatusServiceUnavailable, 1, http.StatusServiceUnavailable, 1},
+		{"RetriesPostWithKey", "POST", http.Header{"Idempotency-Key": {"abc"}}, http.StatusTooManyRequests, 1, http.StatusOK, 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			srv, calls := flakyServer(t, tt.status, tt.failures, nil)
+
+			req, _ := http.NewRequest(tt.method, srv.URL, strings.NewReader("payload"))
+			for k := range tt.header {
+				req.Header.Set(k, tt.header.Get(k))
+			}
+			resp, err := newTestClient().DoContext(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Request failed with %q", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d got %d", tt.want, resp.StatusCode)
+			}
+			if *calls != tt.calls {
+				t.Errorf("Expected %d requests got %d", tt.calls, *calls)
+			}
+		})
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	t.Parallel()
+	srv, calls := flakyServer(t, 0, 0, nil)
+	url := srv.URL
+	srv.Close()
+
+	_, err := newTestClient().GetContext(context.Background(), url)
+	if err == nil {
+		t.Fatalf("Expected the request to fail")
+	}
+	if *calls != 0 {
+		t.Errorf("Expected no requests to reach the server got %d", *calls)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Honoured", func(t *testing.T) {
+		t.Parallel()
+		srv, calls := flakyServer(t, http.StatusServiceUnavailable, 1, http.Header{"Retry-After": {"1"}})
+
+		start := time.Now()
+		resp, err := newTestClient().GetContext(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		resp.Body.Close()
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("Expected to wait for Retry-After got %s", elapsed)
+		}
+		if *calls != 2 {
+			t.Errorf("Expected 2 requests got %d", *calls)
+		}
+	})
+
+	t.Run("StopsAtDeadline", func(t *testing.T) {
+		t.Parallel()
+		srv, calls := flakyServer(t, http.StatusServiceUnavailable, 1, http.Header{"Retry-After": {"10"}})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		resp, err := newTestClient().GetContext(ctx, srv.URL)
+		if err != nil {
+			t.Fatalf("Request failed with %q", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected the last response to be returned got %d", resp.StatusCode)
+		}
+		if *calls != 1 {
+			t.Errorf("Expected 1 request got %d", *calls)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+	p := NewRetryPolicy()
+	p.BaseDelay = 100 * time.Millisecond
+	p.MaxDelay = time.Second
+
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second} {
+		if d := p.Delay(retry, nil); d < max/2 || d > max {
+			t.Errorf("Delay(%d) = %s wanted between %s and %s", retry, d, max/2, max)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package trace
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+)
+
+// Sampler decides whether a new trace with the trace-id is recorded.
+type Sampler func(traceID string) bool
+
+// AlwaysSample returns a Sampler which records every trace.
+func AlwaysSample() Sampler {
+	return func(string) bool { return true }
+}
+
+// NeverSample returns a Sampler which records no trace.
+func NeverSample() Sampler {
+	return func(string) bool { return false }
+}
+
+// RatioSampler returns a Sampler which records the passed ratio of traces, between 0 and 1.
+// The decision is derived from the trace-id so that it is the same for every span of a trace.
+func RatioSampler(ratio float64) Sampler {
+	if ratio >= 1 {
+		return AlwaysSample()
+	}
+	if ratio <= 0 {
+		return NeverSample()
+	}
+	bound := uint64(ratio * math.MaxUint64)
+	return func(traceID string) bool {
+		b, err := hex.DecodeString(traceID)
+		if err != nil || len(b) < 8 {
+			return false
+		}
+		var v uint64
+		for _, c := range b[len(b)-8:] {
+			v = v<<8 | uint64(c)
+		}
+		return v < bound
+	}
+}
+
+func parseFloat(s string, def float64) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
@@ -0,0 +1,233 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wrapp/gokit/env"
+	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+// SpanKind is the kind of a span as defined by OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a span as defined by OTLP.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+const spanKey = "span"
+
+// Event is something which happened during a span e.g an error.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+// Span records an operation e.g an incoming request or an outgoing call. It carries its span
+// context, timing, status, attributes and events. A span is exported by its Tracer when End is
+// called, if it is sampled. All methods are safe for concurrent use and can be called on a nil
+// Span, in which case they do nothing.
+type Span struct {
+	mu            sync.Mutex
+	tracer        *Tracer
+	name          string
+	kind          SpanKind
+	spanContext   requestidmw.SpanContext
+	start         time.Time
+	end           time.Time
+	attributes    map[string]interface{}
+	events        []Event
+	status        StatusCode
+	statusMessage string
+	ended         bool
+}
+
+// SpanContext returns the span context of the span.
+func (s *Span) SpanContext() requestidmw.SpanContext {
+	if s == nil {
+		return requestidmw.SpanContext{}
+	}
+	return s.spanContext
+}
+
+// SetAttribute sets an attribute of the span. Values should be strings, bools, integers or
+// floats, other values are exported as strings.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// SetStatus sets the status of the span. The message is only used with StatusError.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+	s.statusMessage = message
+}
+
+// RecordError adds an `exception` event with the message and type of the error, and sets the
+// status of the span to StatusError. Nil errors are ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, Event{
+		Name: "exception",
+		Time: time.Now(),
+		Attributes: map[string]interface{}{
+			"exception.message": err.Error(),
+			"exception.type":    fmt.Sprintf("%T", err),
+		},
+	})
+	s.status = StatusError
+	s.statusMessage = err.Error()
+}
+
+// End records the end time of the span and hands it to the exporter of its tracer if it is
+// sampled. Calls after the first one have no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.spanContext.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Tracer starts spans and hands the ended ones to its Exporter. The Sampler decides whether a
+// new trace is recorded. Spans which continue a trace of a caller are recorded if the caller
+// recorded it. A Tracer without an Exporter does not record any spans.
+type Tracer struct {
+	service  string
+	sampler  Sampler
+	exporter *Exporter
+}
+
+var (
+	defaultTracerMu sync.RWMutex
+	defaultTracer   = newDefaultTracer()
+)
+
+// NewTracer creates a new Tracer for the service. The exporter can be nil, in which case the
+// spans are not recorded.
+func NewTracer(service string, exporter *Exporter, sampler Sampler) *Tracer {
+	if sampler == nil {
+		sampler = AlwaysSample()
+	}
+	if exporter != nil {
+		exporter.setService(service)
+	}
+	return &Tracer{service: service, sampler: sampler, exporter: exporter}
+}
+
+// newDefaultTracer creates the default tracer from the environment variables. Spans are only
+// exported if `OTEL_EXPORTER_OTLP_ENDPOINT` or `OTEL_EXPORTER_OTLP_TRACES_ENDPOINT` is set.
+// The ratio of new traces which are sampled is read from `OTEL_TRACES_SAMPLER_ARG`.
+func newDefaultTracer() *Tracer {
+	var exporter *Exporter
+	if cfg := ExporterConfigFromEnv(); cfg.Endpoint != "" {
+		exporter = NewExporter(cfg)
+	}
+	sampler := AlwaysSample()
+	if ratio := env.Get("OTEL_TRACES_SAMPLER_ARG"); ratio != "" {
+		sampler = RatioSampler(parseFloat(ratio, 1))
+	}
+	return NewTracer(env.ServiceName(), exporter, sampler)
+}
+
+// DefaultTracer returns the default Tracer which is used by the span middleware and by
+// TraceClient when no other Tracer is set. The service shuts it down on shutdown.
+func DefaultTracer() *Tracer {
+	defaultTracerMu.RLock()
+	defer defaultTracerMu.RUnlock()
+	return defaultTracer
+}
+
+// SetDefaultTracer sets the default Tracer.
+func SetDefaultTracer(t *Tracer) {
+	defaultTracerMu.Lock()
+	defer defaultTracerMu.Unlock()
+	defaultTracer = t
+}
+
+// Start starts a span with the passed span context. A span without a parent starts a new trace
+// and the sampler decides whether it is recorded. Otherwise the span is recorded if its
+// parent was. The sampling decision is set in the span context of the returned span.
+func (t *Tracer) Start(name string, kind SpanKind, sc requestidmw.SpanContext) *Span {
+	if sc.ParentID == "" {
+		sc.Sampled = t.sampler(sc.TraceID)
+	}
+	return &Span{
+		tracer:      t,
+		name:        name,
+		kind:        kind,
+		spanContext: sc,
+		start:       time.Now(),
+		attributes:  map[string]interface{}{},
+	}
+}
+
+// Flush exports all the ended spans which are not exported yet. It blocks until they are
+// exported or the context is done.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Flush(ctx)
+}
+
+// Shutdown exports all the ended spans which are not exported yet and stops the exporter. See
+// Exporter.Shutdown.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// ContextWithSpan returns a context.Context which contains the span. The span context is set
+// through requestidmw.ContextWithSpan as well so that outgoing requests continue the span.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	ctx = requestidmw.ContextWithSpan(ctx, s.SpanContext())
+	return wrpctx.NewWithValue(ctx, spanKey, s)
+}
+
+// SpanFromContext returns the span from the context.Context. It returns nil if there is no
+// span, the methods of Span can still be called on it.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := wrpctx.GetCtxValue(ctx, spanKey).(*Span)
+	return s
+}
@@ -0,0 +1,167 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wrapp/gokit/middleware/requestidmw"
+)
+
+// collector is an OTLP/HTTP collector which stores the received spans.
+type collector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+	names []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req otlpRequest
+	if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		c.names = append(c.names, rs.Resource.Attributes[0].Value["stringValue"].(string))
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func (c *collector) received() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]otlpSpan{}, c.spans...)
+}
+
+func newTestTracer(t *testing.T, sampler Sampler) (*Tracer, *collector) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	exporter := NewExporter(ExporterConfig{Endpoint: srv.URL, BatchSize: 10, Interval: time.Hour})
+	return NewTracer("test-service", exporter, sampler), c
+}
+
+func TestExportSpans(t *testing.T) {
+	t.Parallel()
+	tracer, c := newTestTracer(t, AlwaysSample())
+
+	span := tracer.Start("work", SpanKindInternal, requestidmw.SpanContext{}.Child())
+	span.SetAttribute("items", 3)
+	span.RecordError(errors.New("failed"))
+	span.End()
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush returned %q", err)
+	}
+
+	spans := c.received()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name != "work" || s.TraceID != span.SpanContext().TraceID || s.Status.Code != StatusError {
+		t.Errorf("Unexpected span %+v", s)
+	}
+	if len(s.Events) != 1 || s.Events[0].Name != "exception" {
+		t.Errorf("Expected exception event got %+v", s.Events)
+	}
+	if len(s.Attributes) != 1 || s.Attributes[0].Value["intValue"] != "3" {
+		t.Errorf("Unexpected attributes %+v", s.Attributes)
+	}
+	if c.names[0] != "test-service" {
+		t.Errorf("service.name = %q wanted \"test-service\"", c.names[0])
+	}
+}
+
+func TestExporterShutdown(t *testing.T) {
+	t.Parallel()
+	tracer, c := newTestTracer(t, AlwaysSample())
+
+	tracer.Start("work", SpanKindInternal, requestidmw.SpanContext{}.Child()).End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned %q", err)
+	}
+	if n := len(c.received()); n != 1 {
+		t.Errorf("Expected the queued span to be exported got %d", n)
+	}
+
+	tracer.Start("late", SpanKindInternal, requestidmw.SpanContext{}.Child()).End()
+	if err := tracer.Flush(context.Background()); err != nil || tracer.exporter.Dropped() != 1 {
+		t.Errorf("Expected spans after Shutdown to be dropped got %d, %v", tracer.exporter.Dropped(), err)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected Shutdown to be idempotent got %q", err)
+	}
+}
+
+func TestClientSpan(t *testing.T) {
+	t.Parallel()
+	tracer, c := newTestTracer(t, AlwaysSample())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := New(nil)
+	client.Tracer = tracer
+	client.Retry = nil
+	resp, err := client.GetContext(context.Background(), srv.URL+"/orders?id=1")
+	if err != nil {
+		t.Fatalf("Request failed with %q", err)
+	}
+	resp.Body.Close()
+	tracer.Flush(context.Background())
+
+	spans := c.received()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Kind != SpanKindClient || s.Name != "HTTP GET" || s.Status.Code != StatusError {
+		t.Errorf("Unexpected span %+v", s)
+	}
+	attrs := map[string]map[string]interface{}{}
+	for _, kv := range s.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["http.url"]["stringValue"] != srv.URL+"/orders" || attrs["http.status_code"]["intValue"] != "503" {
+		t.Errorf("Unexpected attributes %v", attrs)
+	}
+}
+
+func TestRatioSampler(t *testing.T) {
+	t.Parallel()
+	tracer, c := newTestTracer(t, RatioSampler(0.5))
+
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		span := tracer.Start("work", SpanKindInternal, requestidmw.SpanContext{}.Child())
+		if span.SpanContext().Sampled {
+			sampled++
+		}
+		span.End()
+	}
+	tracer.Flush(context.Background())
+
+	if sampled < 350 || sampled > 650 {
+		t.Errorf("Expected about half of the traces to be sampled got %d", sampled)
+	}
+	if len(c.received()) != sampled {
+		t.Errorf("Expected %d exported spans got %d", sampled, len(c.received()))
+	}
+
+	// spans which continue a trace follow the decision of the caller
+	parent := requestidmw.SpanContext{TraceID: requestidmw.NewTraceID(), SpanID: requestidmw.NewSpanID(), Sampled: false}
+	if tracer.Start("work", SpanKindInternal, parent.Child()).SpanContext().Sampled {
+		t.Errorf("Expected span to follow the sampling decision of the parent")
+	}
+}
@@ -1,27 +1,54 @@
 package trace
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/sethgrid/pester"
 	"github.com/wrapp/gokit/env"
+	kitlog "github.com/wrapp/gokit/log"
+	"github.com/wrapp/gokit/metrics"
 	"github.com/wrapp/gokit/middleware/requestidmw"
 )
 
-// TraceClient struct provides the data which is required to make http requests. It contains a
-// func which can generate request-ids. It contains the UserAgent which should be set to all
-// outgoing request headers. It also contains the base http client for making the requests.
-// TraceClient uses pester client underlying to provide retries for http requests. By default
-// it retries 3 times if a request fails but it can be customized by passing a custom pester
-// client if needed.
+// TraceClient struct provides the data which is required to make http requests. It contains a func
+// which can generate request-ids and the UserAgent which is set to all outgoing request headers.
+// The requests go through the layers of NewTransport, which are configured with the fields of the
+// TraceClient, see Options for the meaning of each field. The layers are built once on the first
+// request, so the fields must be set before the client is used; later changes are ignored except
+// through SetUserAgent and SetBaseTransport. MaxResponseSize limits the response
+// bodies read by the JSON helpers e.g GetJSON, DefaultMaxResponseSize is used if it is not set.
+// The methods with a Context suffix e.g GetContext read the request-id from the passed
+// context.Context instead of RequestIDFunc. This allows to share a single TraceClient, and
+// its connection pool, between all requests. RequestIDFunc can be nil if only these methods
+// are used.
 type TraceClient struct {
-	RequestIDFunc RequestIDFunc
-	UserAgent     string
-	client        *pester.Client
+	RequestIDFunc   RequestIDFunc
+	UserAgent       string
+	PropagateB3     bool
+	BaggageKeys     []string
+	BaggageHosts    []string
+	BaggagePrefix   string
+	Tracer          *Tracer
+	Retry           *RetryPolicy
+	Breaker         *CircuitBreaker
+	Bulkhead        *Bulkhead
+	Balancer        *LoadBalancer
+	Hedge           *HedgePolicy
+	Cache           CacheStore
+	Compression     *Compression
+	Logger          *kitlog.Logger
+	LogBodies       bool
+	Metrics         *metrics.Registry
+	MaxResponseSize int64
+	client          *http.Client
+
+	mu      sync.Mutex
+	layered *http.Client
 }
 
 // A function type that generates a request-id as a string
@@ -30,10 +57,78 @@ type RequestIDFunc func() string
 // Do performs the passed http.Request. This method can be used to perform any custom
 // http requests. It returns the http.Response object or an error if there was a problem
 // performing this request.
+// If RequestIDFunc is nil then the request-id is read from the context of the request. See
+// DoContext.
 func (t *TraceClient) Do(req *http.Request) (*http.Response, error) {
-	req.Header.Set("User-Agent", t.UserAgent)
-	requestidmw.SetIDInHeader(&req.Header, t.RequestIDFunc())
-	return t.client.Do(req)
+	if t.RequestIDFunc == nil {
+		return t.DoContext(req.Context(), req)
+	}
+	return t.do(req, t.RequestIDFunc())
+}
+
+// DoContext performs the passed http.Request with the passed context.Context. The request-id
+// is read from the context through requestidmw.IDFromCtx. A new request-id is generated with
+// requestidmw.DefaultGenFunc if there is no request-id in the context. The request is
+// cancelled when the context is done.
+func (t *TraceClient) DoContext(ctx context.Context, req *http.Request) (*http.Response, error) {
+	id := requestidmw.IDFromCtx(ctx)
+	if id == "" {
+		id = requestidmw.DefaultGenFunc()()
+	}
+	return t.do(req.WithContext(ctx), id)
+}
+
+// do sets the request-id and user-agent headers and performs the request through the layers
+// of NewTransport, which are configured with the fields of the TraceClient.
+func (t *TraceClient) do(req *http.Request, id string) (*http.Response, error) {
+	t.mu.Lock()
+	agent := t.UserAgent
+	t.mu.Unlock()
+	req.Header.Set("User-Agent", agent)
+	requestidmw.SetIDInHeader(&req.Header, id)
+	return t.layers().Do(req)
+}
+
+// Transport returns the layers of the TraceClient as an http.RoundTripper, e.g for
+// httputil.ReverseProxy. See NewTransport.
+func (t *TraceClient) Transport() http.RoundTripper {
+	return t.layers().Transport
+}
+
+// layers returns the http.Client which sends the requests through the layers of the
+// TraceClient, they are built on first use.
+func (t *TraceClient) layers() *http.Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.layered == nil {
+		client := *t.client
+		client.Transport = NewTransport(t.options())
+		t.layered = &client
+	}
+	return t.layered
+}
+
+// options returns the Options of the layers of the TraceClient.
+func (t *TraceClient) options() Options {
+	return Options{
+		Base:          t.client.Transport,
+		UserAgent:     t.UserAgent,
+		PropagateB3:   t.PropagateB3,
+		BaggageKeys:   t.BaggageKeys,
+		BaggageHosts:  t.BaggageHosts,
+		BaggagePrefix: t.BaggagePrefix,
+		Tracer:        t.Tracer,
+		Retry:         t.Retry,
+		Breaker:       t.Breaker,
+		Bulkhead:      t.Bulkhead,
+		Balancer:      t.Balancer,
+		Hedge:         t.Hedge,
+		Cache:         t.Cache,
+		Compression:   t.Compression,
+		Logger:        t.Logger,
+		LogBodies:     t.LogBodies,
+		Metrics:       t.Metrics,
+	}
 }
 
 // Get sends a GET request to passed url. It returns the http.Response object or an error
@@ -74,29 +169,177 @@ func (t *TraceClient) PostForm(url string, data url.Values) (*http.Response, err
 	return t.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
 }
 
+// Put sends a PUT request to passed url. Put also accepts content-type and the request body
+// which should be sent in the request. It returns the http.Response object or an error if there
+// was a problem performing this request.
+func (t *TraceClient) Put(url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := newRequestWithBody("PUT", url, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return t.Do(req)
+}
+
+// Patch sends a PATCH request to passed url. Patch also accepts content-type and the request
+// body which should be sent in the request. It returns the http.Response object or an error if
+// there was a problem performing this request.
+func (t *TraceClient) Patch(url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := newRequestWithBody("PATCH", url, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return t.Do(req)
+}
+
+// Delete sends a DELETE request to passed url. It returns the http.Response object or an error
+// if there was a problem performing this request.
+func (t *TraceClient) Delete(url string) (*http.Response, error) {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.Do(req)
+}
+
+// Options sends an OPTIONS request to passed url. It returns the http.Response object or an
+// error if there was a problem performing this request.
+func (t *TraceClient) Options(url string) (*http.Response, error) {
+	req, err := http.NewRequest("OPTIONS", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.Do(req)
+}
+
+// GetContext sends a GET request to passed url with the request-id from the context. See
+// DoContext for more information.
+func (t *TraceClient) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.DoContext(ctx, req)
+}
+
+// HeadContext sends a HEAD request to passed url with the request-id from the context. See
+// DoContext for more information.
+func (t *TraceClient) HeadContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.DoContext(ctx, req)
+}
+
+// PostContext sends a POST request to passed url with the request-id from the context. See
+// Post and DoContext for more information.
+func (t *TraceClient) PostContext(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return t.DoContext(ctx, req)
+}
+
+// PostFormContext sends a POST request with form values to passed url with the request-id from
+// the context. See PostForm and DoContext for more information.
+func (t *TraceClient) PostFormContext(ctx context.Context, url string, data url.Values) (*http.Response, error) {
+	return t.PostContext(ctx, url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+}
+
+// PutContext sends a PUT request to passed url with the request-id from the context. See Put
+// and DoContext for more information.
+func (t *TraceClient) PutContext(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := newRequestWithBody("PUT", url, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return t.DoContext(ctx, req)
+}
+
+// PatchContext sends a PATCH request to passed url with the request-id from the context. See
+// Patch and DoContext for more information.
+func (t *TraceClient) PatchContext(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := newRequestWithBody("PATCH", url, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return t.DoContext(ctx, req)
+}
+
+// DeleteContext sends a DELETE request to passed url with the request-id from the context. See
+// DoContext for more information.
+func (t *TraceClient) DeleteContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.DoContext(ctx, req)
+}
+
+// OptionsContext sends an OPTIONS request to passed url with the request-id from the context.
+// See DoContext for more information.
+func (t *TraceClient) OptionsContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("OPTIONS", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.DoContext(ctx, req)
+}
+
+func newRequestWithBody(method, url, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
+}
+
 // SetUserAgent sets the user-agent header for each request sent from the client.
 func (t *TraceClient) SetUserAgent(agent string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.UserAgent = agent
+	t.layered = nil
+}
+
+// SetBaseTransport sets the http.RoundTripper which sends the requests after the layers of the
+// TraceClient, e.g a tracetest.Recorder in tests. http.DefaultTransport is used if it is nil.
+func (t *TraceClient) SetBaseTransport(rt http.RoundTripper) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client := *t.client
+	client.Transport = rt
+	t.client = &client
+	t.layered = nil
 }
 
 // New creates a new TraceClient. It accepts a function which can generate request-ids to be set
-// for the outgoing request. The returned client will retry the request 3 times with a linear
-// backoff if the request failed to execute.
+// for the outgoing request. The function can be nil, in which case the request-id is read from the
+// context of the request. The returned client uses http.DefaultTransport with the DefaultOptions:
+// it retries the requests with the default RetryPolicy and resolves the logical urls of
+// services from environment variables, see NewRetryPolicy and EnvResolver. A CircuitBreaker
+// can be set in Breaker, a Bulkhead in Bulkhead and a Compression in Compression.
 func New(rIdFunc RequestIDFunc) *TraceClient {
-	client := pester.New()
-	client.Backoff = pester.LinearBackoff
-	client.Timeout = 60 * time.Second
-	client.MaxRetries = 3
-	return NewExtendedClient(rIdFunc, client)
+	opts := DefaultOptions()
+	return &TraceClient{
+		RequestIDFunc: rIdFunc,
+		UserAgent:     opts.UserAgent,
+		Retry:         opts.Retry,
+		Balancer:      opts.Balancer,
+		client:        &http.Client{Timeout: opts.Timeout},
+	}
 }
 
 // NewExtendedClient generates an extended client which uses the passed pester client to perform
 // http requests. RequestIDFunc is used to get the request id which is set in the header of the
-// request.
+// request. The retries are left to the pester client, a RetryPolicy can be set in Retry.
 func NewExtendedClient(rIdFunc RequestIDFunc, client *pester.Client) *TraceClient {
 	return &TraceClient{
 		RequestIDFunc: rIdFunc,
 		UserAgent:     env.ServiceName(),
-		client:        client,
+		client:        &http.Client{Transport: pesterTransport{client}},
 	}
 }
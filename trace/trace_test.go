@@ -0,0 +1,113 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s", requestidmw.IDFromHeader(r.Header), r.Header.Get("User-Agent"))
+	}))
+}
+
+func readBody(t *testing.T, resp *http.Response, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Request failed with %q", err)
+	}
+	defer resp.Body.Close()
+	b := make([]byte, 512)
+	n, _ := resp.Body.Read(b)
+	return string(b[:n])
+}
+
+func TestRequestIDFunc(t *testing.T) {
+	t.Parallel()
+	srv := echoServer()
+	defer srv.Close()
+
+	c := New(func() string { return "func-id" })
+	c.SetUserAgent("agent")
+	resp, err := c.Get(srv.URL)
+	if body := readBody(t, resp, err); body != "func-id|agent" {
+		t.Errorf("body = %q wanted \"func-id|agent\"", body)
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	t.Parallel()
+	srv := echoServer()
+	defer srv.Close()
+
+	c := New(nil)
+	ctx := wrpctx.New(context.Background())
+	requestidmw.SetIDInContext(ctx, "ctx-id")
+
+	resp, err := c.GetContext(ctx, srv.URL)
+	if body := readBody(t, resp, err); body != "ctx-id|" {
+		t.Errorf("body = %q wanted \"ctx-id|\"", body)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err = c.Do(req.WithContext(ctx))
+	if body := readBody(t, resp, err); body != "ctx-id|" {
+		t.Errorf("body = %q wanted \"ctx-id|\"", body)
+	}
+
+	resp, err = c.GetContext(context.Background(), srv.URL)
+	if body := readBody(t, resp, err); len(body) < 2 || body == "|" {
+		t.Errorf("Expected a generated request-id got %q", body)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c := New(nil)
+	start := time.Now()
+	if _, err := c.GetContext(ctx, srv.URL); err == nil {
+		t.Errorf("Expected an error for cancelled context")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Request was not cancelled")
+	}
+}
+
+func TestSpanPropagation(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, _ := requestidmw.SpanFromHeader(r.Header, true)
+		fmt.Fprintf(w, "%s|%s|%s", sc.TraceID, sc.SpanID, r.Header.Get("X-B3-ParentSpanId"))
+	}))
+	defer srv.Close()
+
+	parent := requestidmw.SpanContext{TraceID: requestidmw.NewTraceID(), SpanID: requestidmw.NewSpanID(), Sampled: true}
+	ctx := requestidmw.ContextWithSpan(wrpctx.New(context.Background()), parent)
+
+	c := New(nil)
+	c.PropagateB3 = true
+	resp, err := c.GetContext(ctx, srv.URL)
+	body := readBody(t, resp, err)
+	want := parent.TraceID + "|"
+	if len(body) < len(want) || body[:len(want)] != want {
+		t.Errorf("body = %q wanted trace-id %q", body, parent.TraceID)
+	}
+	if body[len(body)-16:] != parent.SpanID || body[len(want):len(want)+16] == parent.SpanID {
+		t.Errorf("Expected a child span of %q got %q", parent.SpanID, body)
+	}
+}
@@ -0,0 +1,244 @@
+package tracetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Mode is the mode of a Recorder.
+type Mode string
+
+const (
+	// ModeAuto replays the cassette if it exists, otherwise it records it.
+	ModeAuto Mode = ""
+	// ModeRecord sends the requests to the downstream services and records the cassette.
+	ModeRecord Mode = "record"
+	// ModeReplay replays the cassette and fails the test if it does not exist.
+	ModeReplay Mode = "replay"
+)
+
+// ModeEnv is the environment variable which sets the mode of the Recorders e.g
+// `TRACETEST_MODE=record go test ./...` records all the cassettes again.
+const ModeEnv = "TRACETEST_MODE"
+
+// NormalizedHeaders are the request headers which change on every run. Their values are
+// replaced with `<Header>` in the cassette and they are ignored when the requests are matched.
+var NormalizedHeaders = []string{
+	"X-Request-Id",
+	"X-Request-Timeout-Ms",
+	"Traceparent",
+	"Tracestate",
+	"Baggage",
+	"X-B3-Traceid",
+	"X-B3-Spanid",
+	"X-B3-Parentspanid",
+	"X-B3-Sampled",
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request of a cassette.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response of a cassette.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Recorder is an http.RoundTripper which records the interactions with the downstream services
+// in a cassette, a JSON file, and replays them in later runs without a network. In record mode
+// the requests are sent with Next and the cassette is saved when the test ends. In replay mode
+// a request is responded with the first unused interaction with the same method, URL and body.
+// The request-id of the request is set in the X-Request-Id header of the replayed response.
+type Recorder struct {
+	Next http.RoundTripper
+
+	t            testing.TB
+	path         string
+	mode         Mode
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewRecorder creates a Recorder for the cassette at path. The mode is read from ModeEnv, see
+// Mode. Set the Recorder as the base transport of the client under test e.g:
+/*
+	rec := tracetest.NewRecorder(t, "testdata/orders.json")
+	client := trace.New(nil)
+	client.SetBaseTransport(rec)
+*/
+func NewRecorder(t testing.TB, path string) *Recorder {
+	t.Helper()
+	r := &Recorder{Next: http.DefaultTransport, t: t, path: path, mode: Mode(os.Getenv(ModeEnv))}
+	if r.mode == ModeAuto {
+		r.mode = ModeReplay
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			r.mode = ModeRecord
+		}
+	}
+
+	if r.mode == ModeReplay {
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(b, &r.interactions)
+		}
+		if err != nil {
+			t.Fatalf("tracetest: cannot read cassette %s: %s", path, err)
+		}
+		r.used = make([]bool, len(r.interactions))
+		return r
+	}
+	t.Cleanup(func() {
+		if err := r.save(); err != nil {
+			t.Errorf("tracetest: cannot save cassette %s: %s", path, err)
+		}
+	})
+	return r
+}
+
+// Mode returns the mode of the Recorder, ModeRecord or ModeReplay.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Interactions returns a copy of the interactions of the cassette.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction{}, r.interactions...)
+}
+
+// RoundTrip records or replays the request.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	rreq := RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: normalize(req.Header),
+		Body:   string(body),
+	}
+	if r.mode == ModeReplay {
+		return r.replay(req, rreq)
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, err := r.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, Interaction{
+		Request: rreq,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     normalize(resp.Header),
+			Body:       string(respBody),
+		},
+	})
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, rreq RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] || in.Request.Method != rreq.Method || in.Request.URL != rreq.URL || in.Request.Body != rreq.Body {
+			continue
+		}
+		r.used[i] = true
+
+		header := http.Header{}
+		for k, vs := range in.Response.Header {
+			header[k] = append([]string{}, vs...)
+		}
+		if id := req.Header.Get("X-Request-Id"); id != "" && header.Get("X-Request-Id") != "" {
+			header.Set("X-Request-Id", id)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewBufferString(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	r.t.Errorf("tracetest: no interaction in cassette %s matches %s %s", r.path, rreq.Method, rreq.URL)
+	return nil, fmt.Errorf("tracetest: no interaction matches %s %s", rreq.Method, rreq.URL)
+}
+
+func (r *Recorder) save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r.interactions); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, buf.Bytes(), 0644)
+}
+
+// normalize returns a copy of the header with the values of NormalizedHeaders replaced.
+func normalize(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	n := http.Header{}
+	for k, vs := range h {
+		n[k] = append([]string{}, vs...)
+	}
+	for _, k := range NormalizedHeaders {
+		if n.Get(k) != "" {
+			n.Set(k, "<"+k+">")
+		}
+	}
+	return n
+}
+
+// readAll reads and closes the body, which may be nil.
+func readAll(body io.ReadCloser) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
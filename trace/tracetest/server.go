@@ -0,0 +1,257 @@
+// tracetest provides helpers to test code which calls downstream services through a
+// TraceClient. A Server is a stub of a downstream service which responds to the requests
+// matching its expectations with canned responses and verifies the number of calls at the end
+// of the test. A Recorder is a transport which records the real interactions with the
+// downstream services in a cassette file and replays them in later runs, so the tests run
+// offline.
+
+// A stub server is used like e.g:
+/*
+	srv := tracetest.NewServer(t)
+	srv.Expect("GET", "/orders/*").Header("Accept", "application/json").RespondJSON(200, order)
+	srv.Expect("POST", "/orders").BodyJSON(in).Respond(201, "").Times(1)
+	client.GetJSON(ctx, srv.URL+"/orders/42", &out)
+*/
+package tracetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Server is a stub server with declarative expectations. A request is responded by the first
+// expectation which matches it and has calls left. Requests which match no expectation fail
+// the test and are responded with 501. The expectations are verified when the test ends.
+type Server struct {
+	*httptest.Server
+
+	t            testing.TB
+	mu           sync.Mutex
+	expectations []*Expectation
+}
+
+// NewServer starts a Server which is closed and verified when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(func() {
+		s.Close()
+		s.Verify()
+	})
+	return s
+}
+
+// Expect adds an expectation for requests with the method and a path which matches the
+// pattern. The pattern uses the syntax of path.Match e.g `/orders/*`. The expectation responds
+// with 200 and an empty body unless Respond is called.
+func (s *Server) Expect(method, pattern string) *Expectation {
+	e := &Expectation{
+		method:  method,
+		pattern: pattern,
+		status:  http.StatusOK,
+		header:  http.Header{},
+		times:   -1,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expectations = append(s.expectations, e)
+	return e
+}
+
+// Verify fails the test if an expectation was not called the expected number of times. An
+// expectation without Times must be called at least once unless it is Optional. Verify is
+// called when the test ends. It returns whether the verification passed.
+func (s *Server) Verify() bool {
+	s.t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := true
+	for _, e := range s.expectations {
+		e.mu.Lock()
+		calls, times, optional := e.calls, e.times, e.optional
+		e.mu.Unlock()
+		switch {
+		case times >= 0 && calls != times:
+			s.t.Errorf("tracetest: %s expected %d calls got %d", e, times, calls)
+			ok = false
+		case times < 0 && calls == 0 && !optional:
+			s.t.Errorf("tracetest: %s was not called", e)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	var match *Expectation
+	for _, e := range s.expectations {
+		if e.take(r, body) {
+			match = e
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if match == nil {
+		s.t.Errorf("tracetest: no expectation matches %s %s", r.Method, r.URL)
+		http.Error(w, fmt.Sprintf("tracetest: no expectation matches %s %s", r.Method, r.URL), http.StatusNotImplemented)
+		return
+	}
+	for k, vs := range match.header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(match.status)
+	w.Write(match.body)
+}
+
+// Expectation is an expected request and its canned response. The methods return the
+// Expectation so that they can be chained.
+type Expectation struct {
+	method   string
+	pattern  string
+	matchers []func(r *http.Request, body []byte) bool
+
+	status int
+	header http.Header
+	body   []byte
+
+	mu       sync.Mutex
+	times    int
+	optional bool
+	calls    int
+}
+
+func (e *Expectation) String() string {
+	return e.method + " " + e.pattern
+}
+
+// Header adds a matcher for a header of the request with the value.
+func (e *Expectation) Header(key, value string) *Expectation {
+	return e.Match(func(r *http.Request, _ []byte) bool { return r.Header.Get(key) == value })
+}
+
+// Query adds a matcher for a query value of the request.
+func (e *Expectation) Query(key, value string) *Expectation {
+	return e.Match(func(r *http.Request, _ []byte) bool { return r.URL.Query().Get(key) == value })
+}
+
+// Body adds a matcher for the body of the request.
+func (e *Expectation) Body(body string) *Expectation {
+	return e.Match(func(_ *http.Request, b []byte) bool { return string(b) == body })
+}
+
+// BodyContains adds a matcher for a part of the body of the request.
+func (e *Expectation) BodyContains(part string) *Expectation {
+	return e.Match(func(_ *http.Request, b []byte) bool { return strings.Contains(string(b), part) })
+}
+
+// BodyJSON adds a matcher for the body of the request which compares it as JSON with the value
+// encoded as JSON, so the order of the keys and the spacing do not matter.
+func (e *Expectation) BodyJSON(v interface{}) *Expectation {
+	want := normalizeJSON(mustJSON(v))
+	return e.Match(func(_ *http.Request, b []byte) bool {
+		got := normalizeJSON(b)
+		return got != nil && reflect.DeepEqual(got, want)
+	})
+}
+
+// Match adds a custom matcher of the request and its body.
+func (e *Expectation) Match(matcher func(r *http.Request, body []byte) bool) *Expectation {
+	e.matchers = append(e.matchers, matcher)
+	return e
+}
+
+// Respond sets the status and the body of the response.
+func (e *Expectation) Respond(status int, body string) *Expectation {
+	e.status = status
+	e.body = []byte(body)
+	return e
+}
+
+// RespondJSON sets the status and the body of the response to the value encoded as JSON.
+func (e *Expectation) RespondJSON(status int, v interface{}) *Expectation {
+	e.header.Set("Content-Type", "application/json")
+	e.status = status
+	e.body = mustJSON(v)
+	return e
+}
+
+// RespondHeader sets a header of the response.
+func (e *Expectation) RespondHeader(key, value string) *Expectation {
+	e.header.Set(key, value)
+	return e
+}
+
+// Times sets the exact number of calls of the expectation. Requests beyond it are matched by
+// the next expectations.
+func (e *Expectation) Times(n int) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.times = n
+	return e
+}
+
+// Optional allows the expectation not to be called.
+func (e *Expectation) Optional() *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.optional = true
+	return e
+}
+
+// Calls returns the number of calls of the expectation.
+func (e *Expectation) Calls() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+// take counts a call if the request matches the expectation and it has calls left.
+func (e *Expectation) take(r *http.Request, body []byte) bool {
+	if e.method != r.Method {
+		return false
+	}
+	if ok, _ := path.Match(e.pattern, r.URL.Path); !ok {
+		return false
+	}
+	for _, m := range e.matchers {
+		if !m(r, body) {
+			return false
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.times >= 0 && e.calls >= e.times {
+		return false
+	}
+	e.calls++
+	return true
+}
+
+func mustJSON(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("tracetest: cannot encode %v: %s", v, err))
+	}
+	return b
+}
+
+func normalizeJSON(b []byte) interface{} {
+	var v interface{}
+	if json.Unmarshal(bytes.TrimSpace(b), &v) != nil {
+		return nil
+	}
+	return v
+}
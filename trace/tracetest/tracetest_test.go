@@ -0,0 +1,158 @@
+package tracetest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wrapp/gokit/internal/testutil"
+	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/trace"
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+type order struct {
+	ID    int    `json:"id"`
+	State string `json:"state"`
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	srv := NewServer(t)
+	get := srv.Expect("GET", "/orders/*").Header("Accept", "application/json").RespondJSON(200, order{42, "paid"})
+	post := srv.Expect("POST", "/orders").BodyJSON(order{ID: 43}).Respond(201, "").RespondHeader("Location", "/orders/43").Times(1)
+	srv.Expect("GET", "/search").Query("q", "paid").Respond(200, "[]").Optional()
+
+	client := trace.New(nil)
+	ctx := context.Background()
+	var out order
+	if err := client.GetJSON(ctx, srv.URL+"/orders/42", &out); err != nil || out != (order{42, "paid"}) {
+		t.Errorf("Unexpected response %+v, %v", out, err)
+	}
+	resp, err := client.PostContext(ctx, srv.URL+"/orders", "application/json", strings.NewReader(`{"state": "", "id": 43}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 201 || resp.Header.Get("Location") != "/orders/43" {
+		t.Errorf("Unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+
+	if get.Calls() != 1 || post.Calls() != 1 {
+		t.Errorf("Unexpected calls %d %d", get.Calls(), post.Calls())
+	}
+	if !srv.Verify() {
+		t.Errorf("Expected verification to pass")
+	}
+}
+
+func TestServerFails(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Unmatched", func(t *testing.T) {
+		t.Parallel()
+		ft := &testutil.FakeT{TB: t}
+		srv := NewServer(ft)
+		srv.Expect("POST", "/orders").Times(1)
+
+		resp, err := http.Get(srv.URL + "/orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotImplemented || !ft.Failed() {
+			t.Errorf("Expected unmatched request to fail got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Calls", func(t *testing.T) {
+		t.Parallel()
+		ft := &testutil.FakeT{TB: t}
+		srv := NewServer(ft)
+		srv.Expect("GET", "/once").Times(1)
+		srv.Expect("GET", "/never")
+
+		for i := 0; i < 2; i++ {
+			resp, err := http.Get(srv.URL + "/once")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+		if srv.Verify() || !ft.Failed() {
+			t.Errorf("Expected verification to fail")
+		}
+	})
+}
+
+func TestRecorder(t *testing.T) {
+	t.Setenv(ModeEnv, "")
+
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Request-Id", requestidmw.IDFromHeader(r.Header))
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + string(body)))
+	}))
+	cassette := filepath.Join(t.TempDir(), "testdata", "orders.json")
+
+	run := func(t *testing.T, id string) *Recorder {
+		rec := NewRecorder(t, cassette)
+		client := trace.New(nil)
+		client.SetBaseTransport(rec)
+		ctx := wrpctx.New(context.Background())
+		requestidmw.SetIDInContext(ctx, id)
+
+		for _, call := range []struct{ method, body, want string }{
+			{"GET", "", "GET /orders "},
+			{"POST", `{"id":1}`, `POST /orders {"id":1}`},
+		} {
+			req, _ := http.NewRequest(call.method, downstream.URL+"/orders", strings.NewReader(call.body))
+			resp, err := client.DoContext(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != call.want || resp.Header.Get("X-Request-Id") != id {
+				t.Errorf("Unexpected response %q %v", body, resp.Header)
+			}
+		}
+		return rec
+	}
+
+	t.Run("Record", func(t *testing.T) {
+		if rec := run(t, "first"); rec.Mode() != ModeRecord || len(rec.Interactions()) != 2 {
+			t.Errorf("Expected 2 recorded interactions got %s %d", rec.Mode(), len(rec.Interactions()))
+		}
+	})
+	downstream.Close()
+
+	b, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "first") || !strings.Contains(string(b), "<X-Request-Id>") {
+		t.Errorf("Expected request-id to be normalized in %s", b)
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		if rec := run(t, "second"); rec.Mode() != ModeReplay {
+			t.Errorf("Expected replay mode got %s", rec.Mode())
+		}
+	})
+
+	t.Run("Unmatched", func(t *testing.T) {
+		ft := &testutil.FakeT{TB: t}
+		client := trace.New(nil)
+		client.Retry = nil
+		client.SetBaseTransport(NewRecorder(ft, cassette))
+		if _, err := client.DeleteContext(context.Background(), downstream.URL+"/orders"); err == nil || !ft.Failed() {
+			t.Errorf("Expected unmatched request to fail")
+		}
+	})
+}
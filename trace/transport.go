@@ -0,0 +1,509 @@
+package trace
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wrapp/gokit/env"
+	kitlog "github.com/wrapp/gokit/log"
+	"github.com/wrapp/gokit/metrics"
+	"github.com/wrapp/gokit/middleware/baggagemw"
+	"github.com/wrapp/gokit/middleware/deadlinemw"
+	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+const statsKey = "round_trip_stats"
+
+// Options contains the settings of the layers composed by NewTransport, the fields of a
+// TraceClient have the same meaning. A nil Retry, Breaker, Bulkhead, Balancer, Hedge, Cache,
+// Compression or Logger disables the layer.
+type Options struct {
+	// Base is the transport which performs the requests, http.DefaultTransport if it is nil.
+	Base http.RoundTripper
+	// Timeout is the timeout of the http.Client created by NewHTTPClient.
+	Timeout time.Duration
+	// UserAgent is set in the `User-Agent` header of the requests.
+	UserAgent string
+	// PropagateB3 sends the span in B3 headers as well as in the W3C `traceparent` header.
+	PropagateB3 bool
+	// BaggageKeys is the allowlist of the wrpctx keys sent as baggage to the BaggageHosts, no
+	// baggage is sent if either is empty.
+	BaggageKeys  []string
+	BaggageHosts []string
+	// BaggagePrefix sends the baggage in headers with the prefix as well as in the `baggage`
+	// header.
+	BaggagePrefix string
+	// Tracer records the spans of the requests, the default tracer is used if it is nil.
+	Tracer *Tracer
+	// Retry decides which requests are retried, see RetryPolicy.
+	Retry *RetryPolicy
+	// Breaker fails fast when a host keeps failing, see CircuitBreaker.
+	Breaker *CircuitBreaker
+	// Bulkhead limits the concurrent requests to each host, or each endpoint of the services of
+	// the Balancer, see Bulkhead.
+	Bulkhead *Bulkhead
+	// Balancer sends logical urls e.g `svc://orders/orders/42` to an endpoint of the service,
+	// see LoadBalancer.
+	Balancer *LoadBalancer
+	// Hedge hedges slow idempotent requests with a second attempt, see HedgePolicy.
+	Hedge *HedgePolicy
+	// Cache caches the responses of GET requests, see CacheTransport and NewCache.
+	Cache CacheStore
+	// Compression compresses the requests and decompresses the responses, see
+	// CompressionTransport.
+	Compression *Compression
+	// Logger logs each request, with the bodies if LogBodies is set, see LoggingTransport.
+	Logger    *kitlog.Logger
+	LogBodies bool
+	// Metrics records the duration and size of the requests per host, the default registry is
+	// used if it is nil.
+	Metrics *metrics.Registry
+}
+
+// DefaultOptions returns the Options of the client created by New: a timeout of 60s, the
+// service name as user-agent, the default RetryPolicy, and a LoadBalancer which resolves the
+// services from environment variables, see EnvResolver. The circuit breaker, the bulkhead and
+// the compression are opt-in, see NewCircuitBreaker, NewBulkhead and NewCompression.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:   60 * time.Second,
+		UserAgent: env.ServiceName(),
+		Retry:     NewRetryPolicy(),
+		Balancer:  NewLoadBalancer(EnvResolver{}),
+	}
+}
+
+// NewHTTPClient creates a standard http.Client which sends the requests through the layers of
+// NewTransport. It can be passed to third-party SDKs which accept an http.Client. The
+// request-id, span and baggage are read from the context of the requests, so the requests
+// must be created with the context of the incoming request e.g http.NewRequestWithContext.
+func NewHTTPClient(opts Options) *http.Client {
+	return &http.Client{Transport: NewTransport(opts), Timeout: opts.Timeout}
+}
+
+// NewTransport composes the gokit layers into an http.RoundTripper, e.g for
+// httputil.ReverseProxy. From the outside in a request goes through RequestIDTransport,
+// UserAgentTransport, TracingTransport, LoggingTransport, CacheTransport, CompressionTransport,
+// MetricsTransport and RetryTransport.
+// Each attempt then goes through HedgeTransport, DeadlineTransport, BalancerTransport,
+// BulkheadTransport and BreakerTransport before it is performed by the Base transport. A hedged
+// attempt goes through the layers after HedgeTransport on its own, e.g to another endpoint.
+func NewTransport(opts Options) http.RoundTripper {
+	var rt http.RoundTripper = opts.Base
+	if opts.Breaker != nil {
+		rt = &BreakerTransport{Next: rt, Breaker: opts.Breaker}
+	}
+	if opts.Bulkhead != nil {
+		rt = &BulkheadTransport{Next: rt, Bulkhead: opts.Bulkhead}
+	}
+	if opts.Balancer != nil {
+		rt = &BalancerTransport{Next: rt, Balancer: opts.Balancer}
+	}
+	rt = &DeadlineTransport{Next: rt}
+	if opts.Hedge != nil {
+		rt = &HedgeTransport{Next: rt, Policy: opts.Hedge}
+	}
+	rt = &RetryTransport{Next: rt, Policy: opts.Retry}
+	rt = &MetricsTransport{Next: rt, Metrics: opts.Metrics}
+	if opts.Compression != nil {
+		rt = &CompressionTransport{Next: rt, Compression: opts.Compression}
+	}
+	if opts.Cache != nil {
+		rt = &CacheTransport{Next: rt, Store: opts.Cache, Metrics: opts.Metrics}
+	}
+	if opts.Logger != nil {
+		rt = &LoggingTransport{Next: rt, Logger: opts.Logger, LogBodies: opts.LogBodies}
+	}
+	rt = &TracingTransport{
+		Next:          rt,
+		Tracer:        opts.Tracer,
+		PropagateB3:   opts.PropagateB3,
+		BaggageKeys:   opts.BaggageKeys,
+		BaggageHosts:  opts.BaggageHosts,
+		BaggagePrefix: opts.BaggagePrefix,
+	}
+	rt = &UserAgentTransport{Next: rt, UserAgent: opts.UserAgent}
+	return &RequestIDTransport{Next: rt}
+}
+
+func next(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		return http.DefaultTransport
+	}
+	return rt
+}
+
+// RequestIDTransport sets the `X-Request-ID` header of the requests which do not have one. The
+// request-id is read from the context of the request or generated with
+// requestidmw.DefaultGenFunc if there is none.
+type RequestIDTransport struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip sets the request-id and performs the request with the next transport.
+func (t *RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if requestidmw.IDFromHeader(req.Header) == "" {
+		id := requestidmw.IDFromCtx(req.Context())
+		if id == "" {
+			id = requestidmw.DefaultGenFunc()()
+		}
+		req = req.Clone(req.Context())
+		requestidmw.SetIDInHeader(&req.Header, id)
+	}
+	return next(t.Next).RoundTrip(req)
+}
+
+// UserAgentTransport sets the `User-Agent` header of the requests which do not have one.
+type UserAgentTransport struct {
+	Next      http.RoundTripper
+	UserAgent string
+}
+
+// RoundTrip sets the user-agent and performs the request with the next transport.
+func (t *UserAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.UserAgent)
+	}
+	return next(t.Next).RoundTrip(req)
+}
+
+// TracingTransport records a client span for each request with Tracer, or the default tracer if
+// it is nil. The span is a child of the span in the context of the request and is propagated in
+// the W3C `traceparent` header and, if PropagateB3 is set, in B3 headers. The BaggageKeys of the
+// wrpctx of the request are sent in the W3C `baggage` header and, if BaggagePrefix is set, in
+// headers with the prefix. The baggage is only sent to the internal BaggageHosts: a host matches
+// an entry equal to it or, if the entry starts with a dot e.g `.internal`, ending with it.
+// Logical urls e.g `svc://orders` are internal. See wrpctx.GetBaggage.
+type TracingTransport struct {
+	Next          http.RoundTripper
+	Tracer        *Tracer
+	PropagateB3   bool
+	BaggageKeys   []string
+	BaggageHosts  []string
+	BaggagePrefix string
+}
+
+// RoundTrip records the span and performs the request with the next transport.
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tracer := t.Tracer
+	if tracer == nil {
+		tracer = DefaultTracer()
+	}
+	req, stats := withStats(req)
+	req = req.Clone(req.Context())
+
+	span := tracer.Start("HTTP "+req.Method, SpanKindClient, requestidmw.SpanFromCtx(req.Context()).Child())
+	defer span.End()
+	requestidmw.SetSpanInHeader(&req.Header, span.SpanContext(), t.PropagateB3)
+	if t.internal(req.URL) {
+		baggagemw.SetInHeader(&req.Header, wrpctx.GetBaggage(req.Context(), t.BaggageKeys...), t.BaggagePrefix)
+	}
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", requestTarget(req))
+	span.SetAttribute("net.peer.name", req.URL.Hostname())
+
+	resp, err := next(t.Next).RoundTrip(req)
+	if stats.retries > 0 {
+		span.SetAttribute("http.resend_count", stats.retries)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return resp, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetStatus(StatusError, resp.Status)
+	}
+	return resp, nil
+}
+
+// internal reports whether the baggage can be sent to the url, see BaggageHosts.
+func (t *TracingTransport) internal(u *url.URL) bool {
+	if u.Scheme == ServiceScheme {
+		return true
+	}
+	host := u.Hostname()
+	for _, h := range t.BaggageHosts {
+		if host == h || strings.HasPrefix(h, ".") && strings.HasSuffix(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoggingTransport logs each request with Logger. The entry contains the method, host, path
+// template, status, duration, retries, sizes and request-id of the request, and the CacheHeader
+// of the response in the `cache` field. The bodies are logged as well if LogBodies is set, they
+// are masked by the redactor of the Logger. See PathTemplate and ContextWithPathTemplate for
+// the path template.
+type LoggingTransport struct {
+	Next      http.RoundTripper
+	Logger    *kitlog.Logger
+	LogBodies bool
+}
+
+// RoundTrip performs the request with the next transport and logs it.
+func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, stats := withStats(req)
+	if t.LogBodies {
+		var err error
+		if req, err = bufferBody(req); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	resp, err := next(t.Next).RoundTrip(req)
+	duration := time.Since(start)
+
+	path, _ := wrpctx.GetCtxValue(req.Context(), pathTemplateKey).(string)
+	if path == "" {
+		path = PathTemplate(req.URL.Path)
+	}
+	fields := log.Fields{
+		"method":      req.Method,
+		"host":        req.URL.Host,
+		"path":        path,
+		"duration_ms": float64(duration) / float64(time.Millisecond),
+		"retries":     stats.retries,
+		"request_id":  requestidmw.IDFromHeader(req.Header),
+	}
+	if req.ContentLength >= 0 {
+		fields["request_bytes"] = req.ContentLength
+	}
+	if t.LogBodies {
+		if body := requestBody(req); body != nil {
+			fields["request_body"] = body
+		}
+	}
+
+	entry := t.Logger.WithContext(req.Context())
+	if err != nil {
+		entry.WithFields(fields).WithError(err).Warn("outbound request failed")
+		return resp, err
+	}
+	fields["status"] = resp.StatusCode
+	if cache := resp.Header.Get(CacheHeader); cache != "" {
+		fields["cache"] = cache
+	}
+	if resp.ContentLength >= 0 {
+		fields["response_bytes"] = resp.ContentLength
+	}
+	if t.LogBodies {
+		if body := responseBody(resp); body != nil {
+			fields["response_body"] = body
+		}
+	}
+	if resp.StatusCode >= 500 {
+		entry.WithFields(fields).Warn("outbound request")
+	} else {
+		entry.WithFields(fields).Info("outbound request")
+	}
+	return resp, nil
+}
+
+// MetricsTransport records the duration of the requests, including retries, and the size of
+// their bodies as histograms per host in Metrics, or the default registry if it is nil.
+type MetricsTransport struct {
+	Next    http.RoundTripper
+	Metrics *metrics.Registry
+}
+
+// RoundTrip performs the request with the next transport and records its metrics.
+func (t *MetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := next(t.Next).RoundTrip(req)
+
+	host, method := req.URL.Host, req.Method
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	m := newClientMetrics(t.Metrics)
+	m.duration.Observe(time.Since(start).Seconds(), host, method, code)
+	if req.ContentLength > 0 {
+		m.requestSize.Observe(float64(req.ContentLength), host, method)
+	}
+	if resp != nil && resp.ContentLength >= 0 {
+		m.responseSize.Observe(float64(resp.ContentLength), host, method)
+	}
+	return resp, err
+}
+
+// RetryTransport retries the requests according to Policy, or the RetryPolicy in the context
+// of the request, see ContextWithRetryPolicy. Requests are not retried if the policy is nil.
+type RetryTransport struct {
+	Next   http.RoundTripper
+	Policy *RetryPolicy
+}
+
+// RoundTrip performs the request with the next transport and retries it.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.Policy
+	if override, ok := wrpctx.GetCtxValue(req.Context(), retryPolicyKey).(*RetryPolicy); ok {
+		p = override
+	}
+	if p == nil || p.MaxRetries <= 0 || !p.Retryable(req) {
+		return next(t.Next).RoundTrip(req)
+	}
+	req, err := bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+	stats, _ := wrpctx.GetCtxValue(req.Context(), statsKey).(*roundTripStats)
+
+	ctx := req.Context()
+	attempt := req
+	for retry := 1; ; retry++ {
+		resp, err := next(t.Next).RoundTrip(attempt)
+		if retry > p.MaxRetries || !p.ShouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay := p.Delay(retry, resp)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		attempt = req.Clone(ctx)
+		if req.GetBody != nil {
+			if attempt.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if stats != nil {
+			stats.retries = retry
+		}
+	}
+}
+
+// DeadlineTransport sends the time left until the deadline of the context of the request in
+// the `X-Request-Timeout-Ms` header, see deadlinemw.
+type DeadlineTransport struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip sets the timeout and performs the request with the next transport.
+func (t *DeadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Deadline(); ok {
+		req = req.Clone(req.Context())
+		deadlinemw.SetTimeoutInHeader(&req.Header, req.Context())
+	}
+	return next(t.Next).RoundTrip(req)
+}
+
+// BreakerTransport performs the requests through Breaker, which fails fast when a host keeps
+// failing. See CircuitBreaker.
+type BreakerTransport struct {
+	Next    http.RoundTripper
+	Breaker *CircuitBreaker
+}
+
+// RoundTrip performs the request with the next transport if the circuit of its host allows it.
+func (t *BreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.Breaker.Do(req, next(t.Next).RoundTrip)
+}
+
+// BulkheadTransport limits the concurrent requests to each host with Bulkhead. See Bulkhead.
+type BulkheadTransport struct {
+	Next     http.RoundTripper
+	Bulkhead *Bulkhead
+}
+
+// RoundTrip performs the request with the next transport when the bulkhead of its host has
+// room.
+func (t *BulkheadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.Bulkhead.Do(req, next(t.Next).RoundTrip)
+}
+
+// BalancerTransport sends the requests to logical urls, e.g `svc://orders/orders/42`, to an
+// endpoint of the service picked by Balancer. See LoadBalancer.
+type BalancerTransport struct {
+	Next     http.RoundTripper
+	Balancer *LoadBalancer
+}
+
+// RoundTrip performs the request with the next transport at an endpoint of its service.
+func (t *BalancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.Balancer.Do(req, next(t.Next).RoundTrip)
+}
+
+// HedgeTransport hedges the slow idempotent requests with a second attempt according to
+// Policy. See HedgePolicy.
+type HedgeTransport struct {
+	Next   http.RoundTripper
+	Policy *HedgePolicy
+}
+
+// RoundTrip performs the request with the next transport, and again if it does not respond in
+// time.
+func (t *HedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.Policy.Do(req, next(t.Next).RoundTrip)
+}
+
+// roundTripStats is shared by the layers of a request through its context, so that the outer
+// layers know how many retries the RetryTransport made.
+type roundTripStats struct {
+	retries int
+}
+
+// withStats returns the request with roundTripStats in its context, adding them if needed.
+func withStats(req *http.Request) (*http.Request, *roundTripStats) {
+	if s, ok := wrpctx.GetCtxValue(req.Context(), statsKey).(*roundTripStats); ok {
+		return req, s
+	}
+	s := &roundTripStats{}
+	return req.WithContext(wrpctx.NewWithValue(req.Context(), statsKey, s)), s
+}
+
+// bufferBody returns a request whose body can be read again for each attempt. Requests created
+// with a bytes or strings reader already can, other bodies are read into memory.
+func bufferBody(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req, nil
+}
+
+// pesterTransport performs the requests with a pester client, for NewExtendedClient.
+type pesterTransport struct {
+	client interface {
+		Do(*http.Request) (*http.Response, error)
+	}
+}
+
+func (t pesterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.client.Do(req)
+}
@@ -0,0 +1,172 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sethgrid/pester"
+
+	"github.com/wrapp/gokit/middleware/requestidmw"
+	"github.com/wrapp/gokit/wrpctx"
+)
+
+func headerServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, _ := requestidmw.SpanFromHeader(r.Header, false)
+		fmt.Fprintf(w, "%s|%s|%s", requestidmw.IDFromHeader(r.Header), r.UserAgent(), sc.TraceID)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func requestContext() (context.Context, requestidmw.SpanContext) {
+	ctx := wrpctx.New(context.Background())
+	requestidmw.SetIDInContext(ctx, "ctx-id")
+	span := requestidmw.SpanContext{}.Child()
+	return requestidmw.ContextWithSpan(ctx, span), span
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Parallel()
+	srv := headerServer(t)
+	opts := DefaultOptions()
+	opts.UserAgent = "sdk"
+	opts.Tracer = NewTracer("test", nil, nil)
+	client := NewHTTPClient(opts)
+
+	ctx, span := requestContext()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := client.Do(req)
+	if body := readBody(t, resp, err); body != "ctx-id|sdk|"+span.TraceID {
+		t.Errorf("Unexpected headers %q", body)
+	}
+	if len(req.Header) != 0 {
+		t.Errorf("Expected the request not to be modified got %v", req.Header)
+	}
+
+	resp, err = client.Get(srv.URL)
+	if body := readBody(t, resp, err); len(body) < 40 || body[:1] == "|" {
+		t.Errorf("Expected a generated request-id and a new trace got %q", body)
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	t.Parallel()
+	srv := headerServer(t)
+	target, _ := url.Parse(srv.URL)
+
+	client := New(nil)
+	client.UserAgent = "proxy"
+	client.Tracer = NewTracer("test", nil, nil)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = client.Transport()
+
+	ctx, span := requestContext()
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+
+	if body := w.Body.String(); body != "ctx-id|proxy|"+span.TraceID {
+		t.Errorf("Unexpected headers %q", body)
+	}
+}
+
+func TestClientLayersBuiltOnce(t *testing.T) {
+	t.Parallel()
+	srv := headerServer(t)
+	client := New(nil)
+	client.Tracer = NewTracer("test", nil, nil)
+
+	rt := client.Transport()
+	if client.Transport() != rt {
+		t.Errorf("Expected the layers to be built once")
+	}
+	calls := 0
+	client.SetBaseTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return http.DefaultTransport.RoundTrip(req)
+	}))
+	if client.Transport() == rt {
+		t.Errorf("Expected SetBaseTransport to rebuild the layers")
+	}
+	resp, err := client.Get(srv.URL)
+	readBody(t, resp, err)
+	if calls != 1 {
+		t.Errorf("Expected the request to be sent with the new base transport got %d calls", calls)
+	}
+}
+
+func TestClientSetUserAgentWhileSending(t *testing.T) {
+	t.Parallel()
+	srv := headerServer(t)
+	client := New(nil)
+	client.Tracer = NewTracer("test", nil, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			client.SetUserAgent(fmt.Sprintf("agent-%d", i))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get(srv.URL)
+		readBody(t, resp, err)
+	}
+	<-done
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestExtendedClient(t *testing.T) {
+	t.Parallel()
+	srv := headerServer(t)
+
+	client := NewExtendedClient(func() string { return "func-id" }, pester.New())
+	client.UserAgent = "pester"
+	client.Tracer = NewTracer("test", nil, nil)
+	resp, err := client.Get(srv.URL)
+	body := readBody(t, resp, err)
+	if len(body) != len("func-id|pester|")+32 || body[:len("func-id|pester|")] != "func-id|pester|" {
+		t.Errorf("Unexpected headers %q", body)
+	}
+}
+
+func TestTransportRetriesBody(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions()
+	opts.Tracer = NewTracer("test", nil, nil)
+	opts.Retry.BaseDelay = time.Millisecond
+	// the body cannot be read again by itself so the transport has to buffer it
+	body := struct{ io.Reader }{strings.NewReader("payload")}
+	req, _ := http.NewRequest("PUT", srv.URL, body)
+	resp, err := NewHTTPClient(opts).Do(req)
+	if body := readBody(t, resp, err); body != "payload" || calls != 2 {
+		t.Errorf("Expected the body to be sent again got %q after %d requests", body, calls)
+	}
+}
@@ -0,0 +1,33 @@
+package wrpctx
+
+import (
+	"context"
+	"fmt"
+)
+
+// GetBaggage returns the values of the passed keys which are set in the internal map of the
+// context, e.g `tenant_id`. The keys are the allowlist of the values which are propagated to
+// downstream services, all other keys stay in the service. Strings, numbers and bools are
+// returned as strings, other values and empty strings are skipped.
+func GetBaggage(ctx context.Context, keys ...string) map[string]string {
+	baggage := map[string]string{}
+	for _, k := range keys {
+		switch v := Get(ctx, k).(type) {
+		case string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+			if s := fmt.Sprint(v); s != "" {
+				baggage[k] = s
+			}
+		}
+	}
+	return baggage
+}
+
+// SetBaggage sets the values of the passed keys in the internal map of the context. The other
+// keys of the baggage are ignored.
+func SetBaggage(ctx context.Context, baggage map[string]string, keys ...string) {
+	for _, k := range keys {
+		if v, ok := baggage[k]; ok {
+			Set(ctx, k, v)
+		}
+	}
+}